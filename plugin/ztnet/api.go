@@ -6,32 +6,110 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// Backend fetches network and member data from a ZeroTier network controller API.
+type Backend interface {
+	// GetNetworkInfo returns the v6 assignment modes and managed routes of networkID.
+	GetNetworkInfo(ctx context.Context, networkID string) (*NetworkInfo, error)
+	// GetMembers returns the members of networkID, authorized or not.
+	GetMembers(ctx context.Context, networkID string) ([]Member, error)
+}
+
+// Discoverer is a Backend that can list the networks the token has access to.
+type Discoverer interface {
+	// ListNetworks returns the ID and name of every network.
+	ListNetworks(ctx context.Context) ([]Network, error)
+}
+
+// networkBackends dispatches every network to the backend it is configured with. Networks that are not
+// configured, like discovered ones, use the default backend.
+type networkBackends struct {
+	def      Backend
+	networks map[string]Backend
+}
+
+func (nb *networkBackends) backend(networkID string) (Backend, error) {
+	if b, ok := nb.networks[networkID]; ok {
+		return b, nil
+	}
+	if nb.def == nil {
+		return nil, fmt.Errorf("ztnet: no backend for network %s", networkID)
+	}
+	return nb.def, nil
+}
+
+// GetNetworkInfo implements Backend.
+func (nb *networkBackends) GetNetworkInfo(ctx context.Context, networkID string) (*NetworkInfo, error) {
+	b, err := nb.backend(networkID)
+	if err != nil {
+		return nil, err
+	}
+	return b.GetNetworkInfo(ctx, networkID)
+}
+
+// GetMembers implements Backend.
+func (nb *networkBackends) GetMembers(ctx context.Context, networkID string) ([]Member, error) {
+	b, err := nb.backend(networkID)
+	if err != nil {
+		return nil, err
+	}
+	return b.GetMembers(ctx, networkID)
+}
+
+// ListNetworks implements Discoverer, networks are discovered with the default backend.
+func (nb *networkBackends) ListNetworks(ctx context.Context) ([]Network, error) {
+	d, ok := nb.def.(Discoverer)
+	if !ok {
+		return nil, fmt.Errorf("ztnet: backend does not support network discovery")
+	}
+	return d.ListNetworks(ctx)
+}
+
 // Client communicates with the ZTNET REST API.
 type Client struct {
 	baseURL    string
-	token      string
+	token      *tokenSource
 	httpClient *http.Client
+	// org scopes all requests to a ZTNET organization, empty means the personal networks of the token.
+	org string
 }
 
 // NewClient returns a Client with DefaultHTTPTimeout set.
 func NewClient(baseURL, token string) *Client {
-	return &Client{baseURL: strings.TrimRight(baseURL, "/"), token: token, httpClient: &http.Client{Timeout: DefaultHTTPTimeout}}
+	return &Client{baseURL: strings.TrimRight(baseURL, "/"), token: staticToken(token), httpClient: &http.Client{Timeout: DefaultHTTPTimeout}}
 }
 
-// NetworkInfo holds v6 assignment mode flags.
+// NetworkInfo holds v6 assignment mode flags, the managed routes and the address pools of a network.
 type NetworkInfo struct {
 	RFC4193  bool
 	SixPlane bool
+	Routes   []*net.IPNet
+	Pools    []IPRange
+}
+
+// Network is a network returned by network discovery.
+type Network struct {
+	ID   string
+	Name string
 }
 
-// Member is an authorised ZeroTier network member.
+// Member is a ZeroTier network member.
 type Member struct {
 	ID   string
 	Name string
-	IPs  []net.IP
+	// IPs are the assigned IPv4 and IPv6 addresses, without the computed RFC4193 and 6plane ones.
+	IPs []net.IP
+	// Description may hold custom records, see parseMemberRecords.
+	Description string
+	Authorized  bool
+	// LastSeen is the last time the member was online, zero when the backend does not know.
+	LastSeen time.Time
+	// Tags are the ZeroTier tags of the member, as "<id>=<value>".
+	Tags []string
 }
 
 type networkInfoResponse struct {
@@ -39,68 +117,202 @@ type networkInfoResponse struct {
 		SixPlane bool `json:"6plane"`
 		RFC4193  bool `json:"rfc4193"`
 	} `json:"v6AssignMode"`
+	Routes []routeResponse `json:"routes"`
+	Pools  []poolResponse  `json:"ipAssignmentPools"`
+}
+
+type poolResponse struct {
+	Start string `json:"ipRangeStart"`
+	End   string `json:"ipRangeEnd"`
+}
+
+type networkResponse struct {
+	NWID string `json:"nwid"`
+	Name string `json:"name"`
+}
+
+type routeResponse struct {
+	Target string  `json:"target"`
+	Via    *string `json:"via"`
 }
 
 type memberResponse struct {
-	ID            string   `json:"id"`
-	Name          string   `json:"name"`
-	Authorized    bool     `json:"authorized"`
-	IPAssignments []string `json:"ipAssignments"`
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	Description   string     `json:"description"`
+	Authorized    bool       `json:"authorized"`
+	IPAssignments []string   `json:"ipAssignments"`
+	LastSeen      timestamp  `json:"lastSeen"`
+	Tags          memberTags `json:"tags"`
 }
 
-// GetNetworkInfo fetches v6AssignMode for networkID.
+// GetNetworkInfo fetches v6AssignMode and the managed routes for networkID.
 func (c *Client) GetNetworkInfo(ctx context.Context, networkID string) (*NetworkInfo, error) {
-	url := fmt.Sprintf("%s/api/v1/network/%s/", c.baseURL, networkID)
+	url := c.networksURL() + networkID + "/"
 	var response networkInfoResponse
-	if err := c.getJSON(ctx, url, &response); err != nil {
+	if err := c.getJSON(ctx, "network", networkID, url, &response); err != nil {
 		return nil, fmt.Errorf("ztnet: api: %w", err)
 	}
-	return &NetworkInfo{RFC4193: response.V6AssignMode.RFC4193, SixPlane: response.V6AssignMode.SixPlane}, nil
+	return response.info(), nil
 }
 
-// GetMembers returns authorized==true members with IPv4-only IPs.
+// GetMembers returns the members of networkID with their assigned IPv4 and IPv6 addresses.
 func (c *Client) GetMembers(ctx context.Context, networkID string) ([]Member, error) {
-	url := fmt.Sprintf("%s/api/v1/network/%s/member/", c.baseURL, networkID)
+	url := c.networksURL() + networkID + "/member/"
 	var response []memberResponse
-	if err := c.getJSON(ctx, url, &response); err != nil {
+	if err := c.getJSON(ctx, "members", networkID, url, &response); err != nil {
 		return nil, fmt.Errorf("ztnet: api: %w", err)
 	}
 
 	members := make([]Member, 0, len(response))
 	for _, m := range response {
-		if !m.Authorized {
+		members = append(members, m.member())
+	}
+	return members, nil
+}
+
+// ListNetworks returns the networks of the organization, or the personal networks of the token.
+func (c *Client) ListNetworks(ctx context.Context) ([]Network, error) {
+	var response []networkResponse
+	if err := c.getJSON(ctx, "networks", "", c.networksURL(), &response); err != nil {
+		return nil, fmt.Errorf("ztnet: api: %w", err)
+	}
+	networks := make([]Network, 0, len(response))
+	for _, n := range response {
+		if n.NWID == "" {
 			continue
 		}
-		member := Member{ID: strings.ToLower(m.ID), Name: strings.ReplaceAll(m.Name, " ", "_")}
-		for _, assignment := range m.IPAssignments {
-			ip := net.ParseIP(assignment)
-			if ip == nil {
-				continue
-			}
-			if ip4 := ip.To4(); ip4 != nil {
-				member.IPs = append(member.IPs, ip4)
-			}
+		networks = append(networks, Network{ID: strings.ToLower(n.NWID), Name: n.Name})
+	}
+	return networks, nil
+}
+
+// networksURL returns the URL of the network collection, with a trailing slash.
+func (c *Client) networksURL() string {
+	if c.org != "" {
+		return fmt.Sprintf("%s/api/v1/org/%s/network/", c.baseURL, c.org)
+	}
+	return c.baseURL + "/api/v1/network/"
+}
+
+// info converts the network response. Routes with a gateway (via) are skipped, as those do not hold
+// member addresses.
+func (r *networkInfoResponse) info() *NetworkInfo {
+	info := &NetworkInfo{RFC4193: r.V6AssignMode.RFC4193, SixPlane: r.V6AssignMode.SixPlane}
+	for _, route := range r.Routes {
+		if route.Via != nil && *route.Via != "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(route.Target)
+		if err != nil {
+			continue
 		}
-		members = append(members, member)
+		info.Routes = append(info.Routes, n)
 	}
-	return members, nil
+	for _, pool := range r.Pools {
+		start, end := net.ParseIP(pool.Start), net.ParseIP(pool.End)
+		if start == nil || end == nil {
+			continue
+		}
+		info.Pools = append(info.Pools, IPRange{Start: start, End: end})
+	}
+	return info
+}
+
+// member converts the member response.
+func (m *memberResponse) member() Member {
+	member := Member{
+		ID:          strings.ToLower(m.ID),
+		Name:        m.Name,
+		Description: m.Description,
+		Authorized:  m.Authorized,
+		LastSeen:    time.Time(m.LastSeen),
+		Tags:        m.Tags,
+	}
+	for _, assignment := range m.IPAssignments {
+		ip := net.ParseIP(assignment)
+		if ip == nil {
+			continue
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		member.IPs = append(member.IPs, ip)
+	}
+	return member
 }
 
-func (c *Client) getJSON(ctx context.Context, url string, dst any) error {
+// timestamp is a time in JSON as RFC 3339 string or as milliseconds since the epoch. Values that are
+// neither decode as the zero time instead of failing the whole response.
+type timestamp time.Time
+
+func (t *timestamp) UnmarshalJSON(b []byte) error {
+	var ms int64
+	if err := json.Unmarshal(b, &ms); err == nil {
+		if ms > 0 {
+			*t = timestamp(time.UnixMilli(ms))
+		}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		if ts, err := time.Parse(time.RFC3339, s); err == nil {
+			*t = timestamp(ts)
+		}
+	}
+	return nil
+}
+
+// memberTags are ZeroTier tags, in JSON as [[id, value], ...]. Values of another shape are ignored
+// instead of failing the whole response.
+type memberTags []string
+
+func (mt *memberTags) UnmarshalJSON(b []byte) error {
+	var pairs [][]int64
+	if err := json.Unmarshal(b, &pairs); err != nil {
+		return nil
+	}
+	for _, p := range pairs {
+		if len(p) == 2 {
+			*mt = append(*mt, fmt.Sprintf("%d=%d", p[0], p[1]))
+		}
+	}
+	return nil
+}
+
+func (c *Client) getJSON(ctx context.Context, endpoint, networkID, url string, dst any) error {
+	return c.token.do(func(token string) error {
+		header := http.Header{"Authorization": {"Bearer " + token}}
+		return getJSON(ctx, c.httpClient, header, endpoint, networkID, url, dst)
+	})
+}
+
+// getJSON fetches url with the extra request header and decodes the response into dst. The request is
+// recorded in the API metrics under endpoint and networkID.
+func getJSON(ctx context.Context, hc *http.Client, header http.Header, endpoint, networkID, url string, dst any) error {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	for key, values := range header {
+		req.Header[key] = values
+	}
 	req.Header.Set("Accept", "application/json")
 
-	resp, err := c.httpClient.Do(req)
+	start := time.Now()
+	resp, err := hc.Do(req)
 	if err != nil {
+		apiRequestDuration.WithLabelValues(endpoint, networkID, "error").Observe(time.Since(start).Seconds())
 		return err
 	}
 	defer resp.Body.Close()
+	apiRequestDuration.WithLabelValues(endpoint, networkID, strconv.Itoa(resp.StatusCode)).Observe(time.Since(start).Seconds())
 
-	if resp.StatusCode != http.StatusOK {
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w with status code %d", errTokenRejected, resp.StatusCode)
+	default:
 		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
 	}
 	if err := json.NewDecoder(resp.Body).Decode(dst); err != nil {
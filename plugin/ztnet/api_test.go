@@ -5,17 +5,19 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
-func TestGetMembersFiltersAndNormalizes(t *testing.T) {
+func TestGetMembers(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/api/v1/network/8056c2e21c000001/member/" {
 			t.Fatalf("unexpected path %s", r.URL.Path)
 		}
 		w.WriteHeader(http.StatusOK)
 		if _, err := w.Write([]byte(`[
-			{"id":"efcc1b0947","name":"node one","authorized":true,"ipAssignments":["10.0.0.2","fc00::1"]},
-			{"id":"deadbeef00","name":"ignored","authorized":false,"ipAssignments":["10.0.0.3"]}
+			{"id":"efcc1b0947","name":"node one","description":"dns: alias=web","authorized":true,"ipAssignments":["10.0.0.2","fc00::1","bogus"],
+			 "lastSeen":"2024-05-01T10:00:00.000Z","tags":[[1,2]]},
+			{"id":"deadbeef00","name":"pending","authorized":false,"ipAssignments":["10.0.0.3"],"lastSeen":null,"tags":{"unexpected":true}}
 		]`)); err != nil {
 			t.Fatalf("write response: %v", err)
 		}
@@ -27,13 +29,22 @@ func TestGetMembersFiltersAndNormalizes(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetMembers error: %v", err)
 	}
-	if len(members) != 1 {
-		t.Fatalf("want 1 member, got %d", len(members))
+	if len(members) != 2 {
+		t.Fatalf("want 2 members, got %d", len(members))
 	}
-	if members[0].Name != "node_one" {
-		t.Fatalf("want normalized name node_one, got %q", members[0].Name)
+	if !members[0].Authorized || members[1].Authorized {
+		t.Fatalf("unexpected authorization %v %v", members[0].Authorized, members[1].Authorized)
 	}
-	if len(members[0].IPs) != 1 || members[0].IPs[0].String() != "10.0.0.2" {
+	if !members[0].LastSeen.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) || !members[1].LastSeen.IsZero() {
+		t.Fatalf("unexpected last seen %v %v", members[0].LastSeen, members[1].LastSeen)
+	}
+	if len(members[0].Tags) != 1 || members[0].Tags[0] != "1=2" || len(members[1].Tags) != 0 {
+		t.Fatalf("unexpected tags %v %v", members[0].Tags, members[1].Tags)
+	}
+	if members[0].Name != "node one" || members[0].Description != "dns: alias=web" {
+		t.Fatalf("unexpected name %q and description %q", members[0].Name, members[0].Description)
+	}
+	if len(members[0].IPs) != 2 || members[0].IPs[0].String() != "10.0.0.2" || members[0].IPs[1].String() != "fc00::1" {
 		t.Fatalf("unexpected IP list %#v", members[0].IPs)
 	}
 }
@@ -41,7 +52,8 @@ func TestGetMembersFiltersAndNormalizes(t *testing.T) {
 func TestGetNetworkInfo(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
-		if _, err := w.Write([]byte(`{"v6AssignMode":{"6plane":true,"rfc4193":false}}`)); err != nil {
+		if _, err := w.Write([]byte(`{"v6AssignMode":{"6plane":true,"rfc4193":false},"routes":[{"target":"10.147.17.0/24","via":null},{"target":"0.0.0.0/0","via":"10.147.17.1"}],
+			"ipAssignmentPools":[{"ipRangeStart":"10.147.17.1","ipRangeEnd":"10.147.17.254"},{"ipRangeStart":"bogus","ipRangeEnd":"10.0.0.1"}]}`)); err != nil {
 			t.Fatalf("write response: %v", err)
 		}
 	}))
@@ -55,6 +67,12 @@ func TestGetNetworkInfo(t *testing.T) {
 	if !info.SixPlane || info.RFC4193 {
 		t.Fatalf("unexpected info %#v", info)
 	}
+	if len(info.Routes) != 1 || info.Routes[0].String() != "10.147.17.0/24" {
+		t.Fatalf("unexpected routes %v", info.Routes)
+	}
+	if len(info.Pools) != 1 || info.Pools[0].Start.String() != "10.147.17.1" || info.Pools[0].End.String() != "10.147.17.254" {
+		t.Fatalf("unexpected pools %v", info.Pools)
+	}
 }
 
 func TestAPIHTTPErrorWrapped(t *testing.T) {
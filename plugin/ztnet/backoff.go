@@ -0,0 +1,31 @@
+package ztnet
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff configures the retry delay of a network whose refresh failed.
+type Backoff struct {
+	// Base is the delay after the first failure, doubled with every subsequent failure.
+	Base time.Duration
+	// Max caps the delay.
+	Max time.Duration
+	// Jitter is the fraction (0-1) by which the delay is randomly shortened or lengthened.
+	Jitter float64
+}
+
+// delay returns the retry delay after the given number of consecutive failures.
+func (b Backoff) delay(failures int) time.Duration {
+	d := b.Base
+	for i := 1; i < failures && d < b.Max; i++ {
+		d *= 2
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	if b.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * b.Jitter * float64(d))
+	}
+	return d
+}
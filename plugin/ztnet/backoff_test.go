@@ -0,0 +1,35 @@
+package ztnet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Base: time.Second, Max: 10 * time.Second}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+	for _, tc := range tests {
+		if got := b.delay(tc.failures); got != tc.want {
+			t.Fatalf("delay(%d) got %s want %s", tc.failures, got, tc.want)
+		}
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := Backoff{Base: 10 * time.Second, Max: time.Minute, Jitter: 0.5}
+	for range 100 {
+		if got := b.delay(1); got < 5*time.Second || got > 15*time.Second {
+			t.Fatalf("delay with jitter out of range: %s", got)
+		}
+	}
+}
@@ -2,95 +2,962 @@ package ztnet
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"net"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
+
+	"github.com/miekg/dns"
 )
 
+// Records is a complete record set built by a single refresh.
+type Records struct {
+	// Hosts maps owner names to member addresses.
+	Hosts map[string][]net.IP
+	// PTR maps reverse names to the owner names they point to.
+	PTR map[string][]string
+	// CNAME maps member aliases to the member name.
+	CNAME map[string]string
+	// TXT maps owner names to the strings of their TXT records.
+	TXT map[string][]string
+	// SRV maps service names to the members offering the service.
+	SRV map[string][]SRV
+	// ReverseZones are the reverse zones derived from network data.
+	ReverseZones []string
+	// Zones are the other zones the records are served in, each gets its own SOA serial.
+	Zones []string
+	// Loaded are the zones of Zones and ReverseZones that have network data. The others are not answered.
+	Loaded []string
+	// Identities maps member addresses to the member that owns them. They are not served, but exposed
+	// as metadata of queries from members.
+	Identities map[string]Identity
+	// Restricted maps the zones that only answer members of their networks to the addresses of those
+	// members.
+	Restricted map[string][]IPRange
+}
+
+// Identity is the ZeroTier identity of a served member.
+type Identity struct {
+	MemberID   string
+	MemberName string
+	NetworkID  string
+	// Tags are the ZeroTier tags of the member, as "<id>=<value>".
+	Tags []string
+}
+
+// SRV is the data of an SRV record.
+type SRV struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   string
+}
+
 // RecordCache is a concurrency-safe in-memory DNS record store.
 type RecordCache struct {
-	mu      sync.RWMutex
-	records map[string][]net.IP
+	mu           sync.RWMutex
+	records      map[string][]net.IP
+	ptr          map[string][]string
+	cname        map[string]string
+	txt          map[string][]string
+	srv          map[string][]SRV
+	identities   map[string]Identity
+	restricted   map[string][]IPRange
+	reverseZones []string
+	zoneNames    []string
+	loaded       []string
+	// names holds every owner name and its ancestors, so empty non-terminals exist too.
+	names map[string]struct{}
+	// wildcard makes names below a member name resolve to the addresses of the member.
+	wildcard bool
+	// zones holds the content hash and SOA serial of each zone.
+	zones map[string]zoneVersion
+	// lastSync is the oldest successful refresh from the API of all networks, zero until every network was
+	// fetched. Data restored from a snapshot file does not count.
+	lastSync time.Time
+	// last is the network data of the last refresh, it is handed over to the caches of a new configuration.
+	last *snapshot
+
+	networks map[string]*networkState
+	// sources are where the networks are fetched from, shared with the caches of other server blocks.
+	sources *networkSources
+	// discoveredNetworks are the networks found by the last successful discovery, discovered is the time
+	// of that discovery and discoverNext the time of the next one.
+	discoveredNetworks []NetworkZone
+	discovered         time.Time
+	discoverNext       time.Time
+	// discoverSynced is the time of the last successful discovery from the API, like networkState.synced.
+	discoverSynced time.Time
+	// members is the number of members served per zone by the last build.
+	members map[string]int
+	// collisions holds per zone the labels shared by several members, with their IDs.
+	collisions map[string]map[string][]string
+	// servers are the addresses of the servers of the server block, the server label of the zone metrics.
+	servers []string
+	// metricZones are the zones metrics were last exported for.
+	metricZones []string
+	// onUpdate is called by refresh with the zones whose records changed.
+	onUpdate func(zones []string)
+
+	// pending holds the networks for which an immediate refresh was requested, with the time of the
+	// request, wake signals the refresh loop.
+	pendingMu sync.Mutex
+	pending   map[string]time.Time
+	wake      chan struct{}
 }
 
-// Replace atomically swaps the entire record set.
-func (rc *RecordCache) Replace(newRecords map[string][]net.IP) {
+// Replace atomically swaps the entire record set. The SOA serial of a zone is only bumped when the
+// content of that zone changed, the changed zones are returned.
+func (rc *RecordCache) Replace(newRecords *Records) []string {
 	rc.mu.Lock()
 	defer rc.mu.Unlock()
 	if newRecords == nil {
+		newRecords = &Records{}
+	}
+	rc.records = newRecords.Hosts
+	if rc.records == nil {
 		rc.records = map[string][]net.IP{}
-		return
 	}
-	rc.records = newRecords
+	rc.ptr = newRecords.PTR
+	if rc.ptr == nil {
+		rc.ptr = map[string][]string{}
+	}
+	rc.cname = newRecords.CNAME
+	if rc.cname == nil {
+		rc.cname = map[string]string{}
+	}
+	rc.txt = newRecords.TXT
+	if rc.txt == nil {
+		rc.txt = map[string][]string{}
+	}
+	rc.srv = newRecords.SRV
+	if rc.srv == nil {
+		rc.srv = map[string][]SRV{}
+	}
+	rc.identities = newRecords.Identities
+	rc.restricted = newRecords.Restricted
+	rc.reverseZones = newRecords.ReverseZones
+	rc.zoneNames = newRecords.Zones
+	rc.loaded = newRecords.Loaded
+
+	rc.names = map[string]struct{}{}
+	for name := range rc.records {
+		addAncestors(rc.names, name)
+	}
+	for name := range rc.ptr {
+		addAncestors(rc.names, name)
+	}
+	for name := range rc.cname {
+		addAncestors(rc.names, name)
+	}
+	for name := range rc.txt {
+		addAncestors(rc.names, name)
+	}
+	for name := range rc.srv {
+		addAncestors(rc.names, name)
+	}
+
+	now := time.Now()
+	var changed []string
+	zones := make(map[string]zoneVersion)
+	for zone, hash := range newRecords.hashes() {
+		v, ok := rc.zones[zone]
+		if ok && v.hash == hash {
+			zones[zone] = v
+			continue
+		}
+		serial := uint32(now.Unix())
+		if ok && serial <= v.serial {
+			serial = v.serial + 1
+		}
+		zones[zone] = zoneVersion{hash: hash, serial: serial, changed: now}
+		changed = append(changed, zone)
+	}
+	rc.zones = zones
+	slices.Sort(changed)
+	return changed
 }
 
-// Lookup returns IPs for a FQDN, or (nil, false) if not found.
+// zoneVersion is the content hash of a zone, with the SOA serial and time of its last change.
+type zoneVersion struct {
+	hash    uint64
+	serial  uint32
+	changed time.Time
+}
+
+// hashes returns the content hash of every zone in r. Records are hashed in canonical order, so the
+// order in which the API returns members and addresses does not matter.
+func (r *Records) hashes() map[string]uint64 {
+	zones := slices.Concat(r.Zones, r.ReverseZones)
+	content := make(map[string][]string, len(zones))
+	for _, zone := range zones {
+		content[zone] = nil
+	}
+	for name, ips := range r.Hosts {
+		zone := plugin.Zones(zones).Matches(name)
+		for _, ip := range ips {
+			content[zone] = append(content[zone], name+" "+ip.String())
+		}
+	}
+	for name, targets := range r.PTR {
+		zone := plugin.Zones(zones).Matches(name)
+		for _, target := range targets {
+			content[zone] = append(content[zone], name+" PTR "+target)
+		}
+	}
+	for name, target := range r.CNAME {
+		zone := plugin.Zones(zones).Matches(name)
+		content[zone] = append(content[zone], name+" CNAME "+target)
+	}
+	for name, txts := range r.TXT {
+		zone := plugin.Zones(zones).Matches(name)
+		for _, txt := range txts {
+			content[zone] = append(content[zone], name+" TXT "+strconv.Quote(txt))
+		}
+	}
+	for name, srvs := range r.SRV {
+		zone := plugin.Zones(zones).Matches(name)
+		for _, srv := range srvs {
+			content[zone] = append(content[zone], fmt.Sprintf("%s SRV %d %d %d %s", name, srv.Priority, srv.Weight, srv.Port, srv.Target))
+		}
+	}
+
+	hashes := make(map[string]uint64, len(zones))
+	for _, zone := range zones {
+		lines := content[zone]
+		slices.Sort(lines)
+		h := fnv.New64a()
+		for _, line := range lines {
+			h.Write([]byte(line))
+			h.Write([]byte{'\n'})
+		}
+		hashes[zone] = h.Sum64()
+	}
+	return hashes
+}
+
+// addAncestors adds name and all of its parent names to names.
+func addAncestors(names map[string]struct{}, name string) {
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		names[name[off:]] = struct{}{}
+	}
+}
+
+// Lookup returns IPs for a FQDN, or (nil, false) if not found. With wildcards enabled, a name that does
+// not exist itself gets the IPs of the closest member name above it.
 func (rc *RecordCache) Lookup(fqdn string) ([]net.IP, bool) {
 	rc.mu.RLock()
 	defer rc.mu.RUnlock()
-	ips, ok := rc.records[strings.ToLower(fqdn)]
+	name := strings.ToLower(fqdn)
+	ips, ok := rc.records[name]
 	if !ok {
-		return nil, false
+		if name, ok = rc.wildcardOwner(name); !ok {
+			return nil, false
+		}
+		ips = rc.records[name]
 	}
 	out := make([]net.IP, len(ips))
 	copy(out, ips)
 	return out, true
 }
 
-func (rc *RecordCache) refreshLoop(ctx context.Context, c *Client, cfg *Config) {
-	if err := rc.refresh(ctx, c, cfg); err != nil {
-		log.Errorf("refresh failed: %v", err)
+// wildcardOwner returns the member name whose wildcard covers name. Explicit names are never covered
+// and the search stops at the apex of the zone name is in. The caller must hold rc.mu.
+func (rc *RecordCache) wildcardOwner(name string) (string, bool) {
+	if !rc.wildcard {
+		return "", false
+	}
+	if _, ok := rc.names[name]; ok {
+		return "", false
 	}
-	ticker := time.NewTicker(cfg.RefreshTTL)
-	defer ticker.Stop()
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		parent := name[off:]
+		if slices.Contains(rc.zoneNames, parent) {
+			return "", false
+		}
+		if _, ok := rc.records[parent]; ok {
+			return parent, true
+		}
+	}
+	return "", false
+}
+
+// LookupPTR returns the names a reverse name points to, or (nil, false) if not found.
+func (rc *RecordCache) LookupPTR(name string) ([]string, bool) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	names, ok := rc.ptr[strings.ToLower(name)]
+	if !ok {
+		return nil, false
+	}
+	out := make([]string, len(names))
+	copy(out, names)
+	return out, true
+}
+
+// LookupCNAME returns the target of an alias, or ("", false) if not found.
+func (rc *RecordCache) LookupCNAME(name string) (string, bool) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	target, ok := rc.cname[strings.ToLower(name)]
+	return target, ok
+}
 
+// LookupTXT returns the TXT strings of a name, or (nil, false) if not found.
+func (rc *RecordCache) LookupTXT(name string) ([]string, bool) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	txt, ok := rc.txt[strings.ToLower(name)]
+	return slices.Clone(txt), ok
+}
+
+// LookupSRV returns the SRV records of a service name, or (nil, false) if not found.
+func (rc *RecordCache) LookupSRV(name string) ([]SRV, bool) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	srv, ok := rc.srv[strings.ToLower(name)]
+	return slices.Clone(srv), ok
+}
+
+// Entries returns a copy of the records at or below zone. Zones and ReverseZones are not set.
+func (rc *RecordCache) Entries(zone string) *Records {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return &Records{
+		Hosts: subdomains(zone, rc.records, slices.Clone),
+		PTR:   subdomains(zone, rc.ptr, slices.Clone),
+		CNAME: subdomains(zone, rc.cname, func(target string) string { return target }),
+		TXT:   subdomains(zone, rc.txt, slices.Clone),
+		SRV:   subdomains(zone, rc.srv, slices.Clone),
+	}
+}
+
+// subdomains returns the entries of m with a name at or below zone, with the values copied by clone.
+func subdomains[V any](zone string, m map[string]V, clone func(V) V) map[string]V {
+	out := make(map[string]V)
+	for name, v := range m {
+		if dns.IsSubDomain(zone, name) {
+			out[name] = clone(v)
+		}
+	}
+	return out
+}
+
+// Exists reports whether name owns any record, has descendants that do or is covered by a wildcard.
+func (rc *RecordCache) Exists(name string) bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	name = strings.ToLower(name)
+	if _, ok := rc.names[name]; ok {
+		return true
+	}
+	_, ok := rc.wildcardOwner(name)
+	return ok
+}
+
+// LookupIdentity returns the identity of the member that owns the address ip, or (Identity{}, false)
+// if no served member does.
+func (rc *RecordCache) LookupIdentity(ip net.IP) (Identity, bool) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	id, ok := rc.identities[ip.String()]
+	return id, ok
+}
+
+// ClientRanges returns the addresses of the clients that may query zone, and whether zone only answers
+// those clients.
+func (rc *RecordCache) ClientRanges(zone string) ([]IPRange, bool) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	ranges, ok := rc.restricted[zone]
+	return ranges, ok
+}
+
+// Serial returns the SOA serial of zone, or zero when the zone is unknown.
+func (rc *RecordCache) Serial(zone string) uint32 {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return rc.zones[zone].serial
+}
+
+// Loaded reports whether network data has been loaded for zone. Until then the zone is not answered.
+func (rc *RecordCache) Loaded(zone string) bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return slices.Contains(rc.loaded, zone)
+}
+
+// LastChange returns the time the content of zone last changed, or the zero time when the zone is unknown.
+func (rc *RecordCache) LastChange(zone string) time.Time {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return rc.zones[zone].changed
+}
+
+// LastSync returns the time of the oldest last successful refresh across all networks, or the zero
+// time when not every network has been loaded yet.
+func (rc *RecordCache) LastSync() time.Time {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return rc.lastSync
+}
+
+// Zones returns the zones of the cached records, without the derived reverse zones.
+func (rc *RecordCache) Zones() []string {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return slices.Clone(rc.zoneNames)
+}
+
+// ReverseZones returns the reverse zones derived from the cached network data.
+func (rc *RecordCache) ReverseZones() []string {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	out := make([]string, len(rc.reverseZones))
+	copy(out, rc.reverseZones)
+	return out
+}
+
+func (rc *RecordCache) refreshLoop(ctx context.Context, b Backend, cfg *Config) {
 	for {
+		rc.schedulePending()
+		if err := rc.refresh(ctx, b, cfg); err != nil {
+			log.Errorf("refresh failed: %v", err)
+		}
+		timer := time.NewTimer(time.Until(rc.nextRefresh(cfg)))
 		select {
 		case <-ctx.Done():
+			timer.Stop()
+			rc.forget(nil)
 			return
-		case <-ticker.C:
-			if err := rc.refresh(ctx, c, cfg); err != nil {
-				log.Errorf("refresh failed: %v", err)
+		case <-timer.C:
+		case <-rc.wakeup():
+			timer.Stop()
+		}
+	}
+}
+
+// Trigger requests an immediate refresh of networkID from the refresh loop, with data fetched at or
+// after at. Caches sharing the network that are triggered for the same event fetch it once.
+func (rc *RecordCache) Trigger(networkID string, at time.Time) {
+	rc.pendingMu.Lock()
+	if rc.pending == nil {
+		rc.pending = make(map[string]time.Time)
+	}
+	if at.After(rc.pending[networkID]) {
+		rc.pending[networkID] = at
+	}
+	rc.pendingMu.Unlock()
+
+	select {
+	case rc.wakeup() <- struct{}{}:
+	default: // the loop is already woken up
+	}
+}
+
+func (rc *RecordCache) wakeup() chan struct{} {
+	rc.pendingMu.Lock()
+	defer rc.pendingMu.Unlock()
+	if rc.wake == nil {
+		rc.wake = make(chan struct{}, 1)
+	}
+	return rc.wake
+}
+
+// schedulePending makes the networks for which a refresh was triggered due now.
+func (rc *RecordCache) schedulePending() {
+	rc.pendingMu.Lock()
+	pending := rc.pending
+	rc.pending = nil
+	rc.pendingMu.Unlock()
+
+	for networkID, at := range pending {
+		if st, ok := rc.networks[networkID]; ok {
+			st.next, st.since = time.Time{}, at
+			continue
+		}
+		// A network we do not know about yet, it may have been created since the last discovery.
+		rc.discoverNext = time.Time{}
+	}
+}
+
+// nextRefresh returns the earliest time a network is due for a refresh.
+func (rc *RecordCache) nextRefresh(cfg *Config) time.Time {
+	next := time.Now().Add(cfg.RefreshTTL)
+	if cfg.Discover != nil && rc.discoverNext.Before(next) {
+		next = rc.discoverNext
+	}
+	for _, st := range rc.networks {
+		if st.next.Before(next) {
+			next = st.next
+		}
+	}
+	return next
+}
+
+// refresh fetches every network that is due and rebuilds the records from the last-good data of all
+// networks. A network that fails to refresh keeps its last-good data, marked as stale, and is retried
+// with exponential backoff.
+func (rc *RecordCache) refresh(ctx context.Context, b Backend, cfg *Config) error {
+	if rc.networks == nil {
+		rc.networks = make(map[string]*networkState)
+	}
+	now := time.Now()
+
+	var errs []error
+	fetched := false
+	networks, err := rc.networkZones(ctx, b, cfg, now)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	rc.forget(networks)
+
+	for _, nz := range networks {
+		st := rc.state(cfg, nz.NetworkID)
+		if now.Before(st.next) {
+			continue
+		}
+		r := st.src.fetch(ctx, b, nz.NetworkID, st.since, now, cfg.refreshTTL(nz), cfg.Backoff)
+		st.next, st.since = r.next(cfg.refreshTTL(nz)), time.Time{}
+		if r.err != nil {
+			err := r.err
+			st.failures = r.failures
+			if !st.updated.IsZero() {
+				err = fmt.Errorf("%w (serving stale data from %s)", err, st.updated.Format(time.RFC3339))
+			}
+			errs = append(errs, fmt.Errorf("ztnet: cache: network %s: %w", nz.NetworkID, err))
+			continue
+		}
+		if r.started.After(st.synced) {
+			st.synced = r.started
+		}
+		if !r.started.After(st.updated) {
+			continue // the data the cache already has
+		}
+		st.info, st.members, st.updated, st.failures, st.dropped = r.info, r.members, r.started, 0, false
+		fetched = true
+	}
+
+	records := rc.build(cfg, networks, now)
+	if changed := rc.Replace(records); len(changed) > 0 && rc.onUpdate != nil {
+		rc.onUpdate(changed)
+	}
+	rc.updateMetrics(networks, records)
+	rc.updateLastSync(cfg, networks)
+
+	last := rc.snapshot(cfg, networks, now)
+	rc.mu.Lock()
+	rc.last = last
+	rc.mu.Unlock()
+	if fetched && cfg.Snapshot != "" {
+		if err := writeSnapshot(cfg.Snapshot, last); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// updateLastSync sets the time of the oldest last successful refresh from the API of networks, or the zero
+// time when not every network has been fetched yet.
+func (rc *RecordCache) updateLastSync(cfg *Config, networks []NetworkZone) {
+	// With discovery, the list of networks is only complete after a successful discovery.
+	var lastSync time.Time
+	synced := true
+	if cfg.Discover != nil {
+		lastSync, synced = rc.discoverSynced, !rc.discoverSynced.IsZero()
+	}
+	for _, nz := range networks {
+		st := rc.networks[nz.NetworkID]
+		if st == nil || st.synced.IsZero() {
+			synced = false
+			break
+		}
+		if lastSync.IsZero() || st.synced.Before(lastSync) {
+			lastSync = st.synced
+		}
+	}
+	if !synced {
+		lastSync = time.Time{}
+	}
+	rc.mu.Lock()
+	rc.lastSync = lastSync
+	rc.mu.Unlock()
+}
+
+// state returns the state of networkID, with the source it is fetched from.
+func (rc *RecordCache) state(cfg *Config, networkID string) *networkState {
+	if rc.networks == nil {
+		rc.networks = make(map[string]*networkState)
+	}
+	st, ok := rc.networks[networkID]
+	if !ok {
+		st = &networkState{}
+		rc.networks[networkID] = st
+	}
+	if st.src == nil {
+		st.src = rc.sources.acquire(cfg.endpoint(networkID) + " " + networkID)
+	}
+	return st
+}
+
+// forget drops the state of networks that are no longer served, like deleted discovered networks, and
+// gives up their sources.
+func (rc *RecordCache) forget(networks []NetworkZone) {
+	for id, st := range rc.networks {
+		if !slices.ContainsFunc(networks, func(nz NetworkZone) bool { return nz.NetworkID == id }) {
+			delete(rc.networks, id)
+			if rc.sources.release(st.src) {
+				networkStale.DeleteLabelValues(id)
+				lastSuccessTime.DeleteLabelValues(id)
+			}
+		}
+	}
+}
+
+// updateMetrics sets the per network and per zone gauges after a refresh.
+func (rc *RecordCache) updateMetrics(networks []NetworkZone, records *Records) {
+	zones := slices.Concat(records.Zones, records.ReverseZones)
+	for _, zone := range rc.metricZones {
+		if slices.Contains(zones, zone) {
+			continue
+		}
+		for _, server := range rc.servers {
+			zoneMembers.DeleteLabelValues(server, zone)
+			zoneRecords.DeleteLabelValues(server, zone)
+			zoneSerial.DeleteLabelValues(server, zone)
+			zoneLastChange.DeleteLabelValues(server, zone)
+			nameCollisions.DeleteLabelValues(server, zone)
+		}
+	}
+	rc.metricZones = zones
+
+	for _, nz := range networks {
+		if rc.networks[nz.NetworkID].failures > 0 {
+			networkStale.WithLabelValues(nz.NetworkID).Set(1)
+		} else {
+			networkStale.WithLabelValues(nz.NetworkID).Set(0)
+		}
+	}
+
+	counts := make(map[string]int)
+	for name, ips := range records.Hosts {
+		counts[plugin.Zones(zones).Matches(name)] += len(ips)
+	}
+	for name, targets := range records.PTR {
+		counts[plugin.Zones(zones).Matches(name)] += len(targets)
+	}
+	for name := range records.CNAME {
+		counts[plugin.Zones(zones).Matches(name)]++
+	}
+	for name, txts := range records.TXT {
+		counts[plugin.Zones(zones).Matches(name)] += len(txts)
+	}
+	for name, srvs := range records.SRV {
+		counts[plugin.Zones(zones).Matches(name)] += len(srvs)
+	}
+	for _, server := range rc.servers {
+		for _, zone := range zones {
+			zoneRecords.WithLabelValues(server, zone).Set(float64(counts[zone]))
+			zoneSerial.WithLabelValues(server, zone).Set(float64(rc.Serial(zone)))
+			zoneLastChange.WithLabelValues(server, zone).Set(float64(rc.LastChange(zone).Unix()))
+		}
+		for _, nz := range networks {
+			zoneMembers.WithLabelValues(server, nz.Zone).Set(float64(rc.members[nz.Zone]))
+			collided := 0
+			for _, ids := range rc.collisions[nz.Zone] {
+				collided += len(ids)
+			}
+			nameCollisions.WithLabelValues(server, nz.Zone).Set(float64(collided))
+		}
+	}
+}
+
+// networkState is the last-good data of a single network and the schedule of its next refresh.
+// It is only accessed from refresh.
+type networkState struct {
+	src     *networkSource
+	info    *NetworkInfo
+	members []Member
+	updated time.Time // time of the last successful refresh
+	synced  time.Time // time of the last successful refresh from the API, zero for data from a snapshot file
+	next    time.Time // time of the next refresh attempt
+	since   time.Time // a triggered refresh needs data fetched at or after since
+
+	failures int  // consecutive failed refreshes, non-zero means the data is stale
+	dropped  bool // data is older than max_stale and no longer served
+}
+
+func fetchNetwork(ctx context.Context, b Backend, networkID string) (*NetworkInfo, []Member, error) {
+	info, err := b.GetNetworkInfo(ctx, networkID)
+	if err != nil {
+		return nil, nil, err
+	}
+	members, err := b.GetMembers(ctx, networkID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, members, nil
+}
+
+// build creates the records of all networks that have last-good data which is not older than max_stale.
+func (rc *RecordCache) build(cfg *Config, networks []NetworkZone, now time.Time) *Records {
+	records := &Records{
+		Hosts: make(map[string][]net.IP),
+		PTR:   make(map[string][]string),
+		CNAME: make(map[string]string),
+		TXT:   make(map[string][]string),
+		SRV:   make(map[string][]SRV),
+
+		Identities: make(map[string]Identity),
+		Restricted: make(map[string][]IPRange),
+	}
+
+	// served are the members that pass the filter, with the zone their names are in.
+	type servedMember struct {
+		nz      NetworkZone
+		info    *NetworkInfo
+		member  Member
+		zone    string
+		pending bool
+	}
+	var served []servedMember
+	// zonesOf holds the zones every network is served in, restricted the zones that only answer members.
+	zonesOf := make(map[string][]string)
+	restricted := make(map[string]bool)
+	infos := make(map[string]*NetworkInfo)
+	addrs := make(map[string][]net.IP)
+	byZone := make(map[string][]Member)
+	members := make(map[string]int)
+	for _, nz := range networks {
+		st := rc.networks[nz.NetworkID]
+		if st == nil || st.info == nil {
+			continue
+		}
+		if st.failures > 0 && cfg.MaxStale > 0 && now.Sub(st.updated) > cfg.MaxStale {
+			if !st.dropped {
+				log.Warningf("Dropping records of network %s, last successful refresh was %s ago", nz.NetworkID, now.Sub(st.updated).Round(time.Second))
+				st.dropped = true
+			}
+			continue
+		}
+		// Computed addresses that are not served get no reverse zone either.
+		sources := cfg.ipv6Sources(nz)
+		info := *st.info
+		info.RFC4193 = info.RFC4193 && sources.RFC4193
+		info.SixPlane = info.SixPlane && sources.SixPlane
+		var reverse []string
+		if !cfg.NoReverse {
+			reverse = reverseZones(nz.NetworkID, &info)
+			records.ReverseZones = append(records.ReverseZones, reverse...)
+		}
+		zonesOf[nz.NetworkID] = append([]string{nz.Zone}, reverse...)
+		records.Loaded = append(records.Loaded, zonesOf[nz.NetworkID]...)
+		infos[nz.NetworkID] = &info
+		if cfg.membersOnly(nz) {
+			for _, zone := range zonesOf[nz.NetworkID] {
+				restricted[zone] = true
+			}
+		}
+		for _, member := range st.members {
+			ok, pending := cfg.Filter.match(member, now)
+			if !ok {
+				continue
+			}
+			zone := nz.Zone
+			if pending {
+				zone = dnsutil.Join(cfg.Filter.Pending, nz.Zone)
+			}
+			if !sources.Assigned {
+				member.IPs = slices.DeleteFunc(slices.Clone(member.IPs), func(ip net.IP) bool { return ip.To4() == nil })
+			}
+			served = append(served, servedMember{nz: nz, info: &info, member: member, zone: zone, pending: pending})
+			byZone[zone] = append(byZone[zone], member)
+			members[nz.Zone]++
+		}
+	}
+	rc.members = members
+
+	// Names are unique per zone, so collisions are resolved over the members of all networks in a zone.
+	labels := make(map[string]map[string]string)
+	collisions := make(map[string]map[string][]string)
+	for zone, members := range byZone {
+		labels[zone], collisions[zone] = memberLabels(members, cfg.IDNA, cfg.Duplicates)
+	}
+	rc.logCollisions(collisions, cfg.Duplicates)
+
+	for _, sm := range served {
+		member := sm.member
+		ips := memberAddresses(sm.nz.NetworkID, sm.info, member)
+
+		// Members without a usable name, or that lost it in a collision, only get the names that do not
+		// use it.
+		tmpls := sm.nz.Names
+		if len(tmpls) == 0 {
+			tmpls = cfg.Names
+		}
+		if len(tmpls) == 0 {
+			tmpls = defaultNames
+		}
+		names := memberNames(tmpls, sm.zone, sm.nz.NetworkID, member.ID, labels[sm.zone][member.ID])
+		if len(names) == 0 {
+			log.Debugf("Member %s of network %s has no valid name", member.ID, sm.nz.NetworkID)
+			continue
+		}
+		for _, name := range names {
+			records.Hosts[name] = append(records.Hosts[name], ips...)
+		}
+		// Pending members only get address records, so they can not claim aliases, services or addresses.
+		if sm.pending {
+			continue
+		}
+		addrs[sm.nz.NetworkID] = append(addrs[sm.nz.NetworkID], ips...)
+		id := Identity{MemberID: member.ID, MemberName: member.Name, NetworkID: sm.nz.NetworkID, Tags: member.Tags}
+		for _, ip := range ips {
+			if _, ok := records.Identities[ip.String()]; !ok {
+				records.Identities[ip.String()] = id
+			}
+		}
+		addMemberRecords(records, cfg, sm.zone, member, names[0], ips)
+		if cfg.NoReverse {
+			continue
+		}
+		// PTR records point to the first name only, the other names are aliases.
+		for _, ip := range ips {
+			rev, err := dns.ReverseAddr(ip.String())
+			if err != nil {
+				continue
 			}
+			if !slices.Contains(records.PTR[rev], names[0]) {
+				records.PTR[rev] = append(records.PTR[rev], names[0])
+			}
+		}
+	}
+	// A restricted zone answers the members of every network served in it.
+	for _, nz := range networks {
+		var ranges []IPRange
+		for _, zone := range zonesOf[nz.NetworkID] {
+			if !restricted[zone] {
+				continue
+			}
+			if ranges == nil {
+				ranges = clientRanges(nz.NetworkID, infos[nz.NetworkID], addrs[nz.NetworkID])
+			}
+			records.Restricted[zone] = append(records.Restricted[zone], ranges...)
 		}
 	}
+	// A name with a CNAME can not have other data, so aliases of existing names are dropped.
+	for alias := range records.CNAME {
+		if _, ok := records.Hosts[alias]; ok {
+			log.Debugf("Ignoring alias %s, the name is already in use", alias)
+			delete(records.CNAME, alias)
+		}
+	}
+	slices.Sort(records.ReverseZones)
+	records.ReverseZones = slices.Compact(records.ReverseZones)
+	for _, nz := range networks {
+		records.Zones = append(records.Zones, nz.Zone)
+	}
+	if !cfg.NoReverse {
+		records.Zones = append(records.Zones, cfg.ReverseZones...)
+		// A configured reverse zone may hold the addresses of every network, it is answered once any has data.
+		if len(records.Loaded) > 0 {
+			records.Loaded = append(records.Loaded, cfg.ReverseZones...)
+		}
+	}
+	slices.Sort(records.Zones)
+	records.Zones = slices.Compact(records.Zones)
+	slices.Sort(records.Loaded)
+	records.Loaded = slices.Compact(records.Loaded)
+	return records
 }
 
-func (rc *RecordCache) refresh(ctx context.Context, c *Client, cfg *Config) error {
-	records := make(map[string][]net.IP)
-	for _, nz := range cfg.Networks {
-		netInfo, err := c.GetNetworkInfo(ctx, nz.NetworkID)
-		if err != nil {
-			return fmt.Errorf("ztnet: cache: %w", err)
-		}
-		members, err := c.GetMembers(ctx, nz.NetworkID)
-		if err != nil {
-			return fmt.Errorf("ztnet: cache: %w", err)
-		}
-		for _, member := range members {
-			names := []string{member.Name + "." + nz.Zone, member.ID + "." + nz.Zone}
-			for _, name := range names {
-				fqdn := strings.ToLower(strings.TrimSuffix(name, ".") + ".")
-				records[fqdn] = append(records[fqdn], member.IPs...)
-				if netInfo.RFC4193 {
-					ip, err := RFC4193(nz.NetworkID, member.ID)
-					if err != nil {
-						return fmt.Errorf("ztnet: cache: %w", err)
-					}
-					records[fqdn] = append(records[fqdn], ip)
-				}
-				if netInfo.SixPlane {
-					ip, err := SixPlane(nz.NetworkID, member.ID)
-					if err != nil {
-						return fmt.Errorf("ztnet: cache: %w", err)
-					}
-					records[fqdn] = append(records[fqdn], ip)
-				}
+// memberAddresses returns the assigned addresses of member followed by its computed RFC4193 and 6plane
+// addresses, when info enables them. Computed addresses that are assigned as well are only returned once.
+func memberAddresses(networkID string, info *NetworkInfo, member Member) []net.IP {
+	ips := slices.Clone(member.IPs)
+	var computed []net.IP
+	if info.RFC4193 {
+		if ip, err := RFC4193(networkID, member.ID); err == nil {
+			computed = append(computed, ip)
+		}
+	}
+	if info.SixPlane {
+		if ip, err := SixPlane(networkID, member.ID); err == nil {
+			computed = append(computed, ip)
+		}
+	}
+	for _, ip := range computed {
+		if !slices.ContainsFunc(ips, ip.Equal) {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// logCollisions logs the name collisions that are new since the last build and keeps them for the
+// metrics.
+func (rc *RecordCache) logCollisions(collisions map[string]map[string][]string, policy string) {
+	if policy == "" {
+		policy = DuplicateMerge
+	}
+	for zone, labels := range collisions {
+		for label, ids := range labels {
+			if slices.Equal(rc.collisions[zone][label], ids) {
+				continue
+			}
+			log.Warningf("Members %s share the name %s, applying duplicate policy %q", strings.Join(ids, ", "), dnsutil.Join(label, zone), policy)
+		}
+	}
+	rc.collisions = collisions
+}
+
+// addMemberRecords adds the custom records from the description of member to records, target is the
+// name the records point to. With groups enabled, ips are added to the names of the groups of member.
+func addMemberRecords(records *Records, cfg *Config, zone string, member Member, target string, ips []net.IP) {
+	custom, err := parseMemberRecords(member.Description, cfg.IDNA)
+	if err != nil {
+		log.Debugf("Invalid custom records of member %s: %v", member.ID, err)
+	}
+	for _, alias := range custom.Aliases {
+		name := dnsutil.Join(alias, zone)
+		if other, ok := records.CNAME[name]; ok && other != target {
+			log.Debugf("Ignoring alias %s of member %s, it already points to %s", name, member.ID, other)
+			continue
+		}
+		records.CNAME[name] = target
+	}
+	records.TXT[target] = append(records.TXT[target], custom.TXT...)
+	if len(records.TXT[target]) == 0 {
+		delete(records.TXT, target)
+	}
+	for _, srv := range custom.SRV {
+		name := dnsutil.Join(srv.Service, zone)
+		records.SRV[name] = append(records.SRV[name], SRV{Priority: srv.Priority, Weight: srv.Weight, Port: srv.Port, Target: target})
+	}
+	if cfg.Groups == "" {
+		return
+	}
+	groups := custom.Groups
+	for group, tags := range cfg.GroupTags {
+		if hasTag(member.Tags, tags) {
+			groups = append(groups, group)
+		}
+	}
+	slices.Sort(groups)
+	for _, group := range slices.Compact(groups) {
+		name := dnsutil.Join(group, cfg.Groups, zone)
+		for _, ip := range ips {
+			if !slices.ContainsFunc(records.Hosts[name], ip.Equal) {
+				records.Hosts[name] = append(records.Hosts[name], ip)
 			}
 		}
 	}
-	rc.Replace(records)
-	return nil
 }
@@ -1,20 +1,68 @@
 package ztnet
 
 import (
+	"context"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestCacheReplaceLookup(t *testing.T) {
 	rc := &RecordCache{}
-	rc.Replace(map[string][]net.IP{"host.example.": {net.ParseIP("10.0.0.1")}})
+	rc.Replace(&Records{Hosts: map[string][]net.IP{"host.example.": {net.ParseIP("10.0.0.1")}}})
 	ips, ok := rc.Lookup("host.example.")
 	if !ok || len(ips) != 1 || ips[0].String() != "10.0.0.1" {
 		t.Fatalf("unexpected result ok=%v ips=%v", ok, ips)
 	}
 }
 
+func TestCacheReplaceChanged(t *testing.T) {
+	rc := &RecordCache{}
+	records := func() *Records {
+		return &Records{
+			Hosts:        map[string][]net.IP{"host.example.": {net.ParseIP("10.0.0.1"), net.ParseIP("fc00::1")}, "host.other.": {net.ParseIP("10.0.1.1")}},
+			PTR:          map[string][]string{"1.0.0.10.in-addr.arpa.": {"host.example."}},
+			Zones:        []string{"example.", "other."},
+			ReverseZones: []string{"10.in-addr.arpa."},
+		}
+	}
+	if changed := rc.Replace(records()); len(changed) != 3 {
+		t.Fatalf("expected all zones to change on first replace, got %v", changed)
+	}
+	serial := rc.Serial("example.")
+	if serial == 0 || rc.LastChange("example.").IsZero() {
+		t.Fatalf("expected serial and last change for example., got %d", serial)
+	}
+
+	same := records()
+	same.Hosts["host.example."] = []net.IP{net.ParseIP("fc00::1"), net.ParseIP("10.0.0.1")}
+	if changed := rc.Replace(same); len(changed) != 0 {
+		t.Fatalf("did not expect reordered records to change, got %v", changed)
+	}
+	if rc.Serial("example.") != serial {
+		t.Fatal("did not expect serial to change")
+	}
+
+	different := records()
+	different.Hosts["host.other."] = []net.IP{net.ParseIP("10.0.1.2")}
+	if changed := rc.Replace(different); len(changed) != 1 || changed[0] != "other." {
+		t.Fatalf("expected only other. to change, got %v", changed)
+	}
+	if rc.Serial("example.") != serial {
+		t.Fatal("did not expect serial of unchanged zone to change")
+	}
+
+	different.Hosts["host.example."] = nil
+	if rc.Replace(different); rc.Serial("example.") <= serial {
+		t.Fatalf("expected serial to increase, got %d after %d", rc.Serial("example."), serial)
+	}
+}
+
 func TestCacheLookupUnknown(t *testing.T) {
 	rc := &RecordCache{}
 	if ips, ok := rc.Lookup("unknown.example."); ok || ips != nil {
@@ -22,6 +70,19 @@ func TestCacheLookupUnknown(t *testing.T) {
 	}
 }
 
+func TestCacheExists(t *testing.T) {
+	rc := &RecordCache{}
+	rc.Replace(&Records{Hosts: map[string][]net.IP{"host.sub.example.": {net.ParseIP("10.0.0.1")}}})
+	for _, name := range []string{"host.sub.example.", "HOST.sub.example.", "sub.example.", "example."} {
+		if !rc.Exists(name) {
+			t.Fatalf("expected %s to exist", name)
+		}
+	}
+	if rc.Exists("other.example.") {
+		t.Fatal("did not expect other.example. to exist")
+	}
+}
+
 func TestCacheReplaceNil(t *testing.T) {
 	rc := &RecordCache{}
 	rc.Replace(nil)
@@ -32,7 +93,7 @@ func TestCacheReplaceNil(t *testing.T) {
 
 func TestCacheConcurrentReplaceLookup(t *testing.T) {
 	rc := &RecordCache{}
-	rc.Replace(map[string][]net.IP{"host.example.": {net.ParseIP("10.0.0.1")}})
+	rc.Replace(&Records{Hosts: map[string][]net.IP{"host.example.": {net.ParseIP("10.0.0.1")}}})
 
 	var wg sync.WaitGroup
 	for range 100 {
@@ -43,8 +104,310 @@ func TestCacheConcurrentReplaceLookup(t *testing.T) {
 		}()
 		go func() {
 			defer wg.Done()
-			rc.Replace(map[string][]net.IP{"host.example.": {net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}})
+			rc.Replace(&Records{Hosts: map[string][]net.IP{"host.example.": {net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}}})
 		}()
 	}
 	wg.Wait()
 }
+
+func TestCacheRefreshBuildsReverse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body string
+		switch r.URL.Path {
+		case "/api/v1/network/8056c2e21c000001/":
+			body = `{"v6AssignMode":{"6plane":false,"rfc4193":true},"routes":[{"target":"10.147.17.0/24","via":null}]}`
+		case "/api/v1/network/8056c2e21c000001/member/":
+			body = `[{"id":"efcc1b0947","name":"node","authorized":true,"ipAssignments":["10.147.17.2"]}]`
+		default:
+			t.Fatalf("unexpected path %s", r.URL.Path)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatalf("write response: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := &Config{Networks: []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}}, DNSTTL: 30 * time.Second}
+	rc := &RecordCache{}
+	if err := rc.refresh(context.Background(), NewClient(ts.URL, "token"), cfg); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+
+	names, ok := rc.LookupPTR("2.17.147.10.in-addr.arpa.")
+	if !ok || len(names) != 1 || names[0] != "node.home.lan." {
+		t.Fatalf("unexpected IPv4 PTR ok=%v names=%v", ok, names)
+	}
+	names, ok = rc.LookupPTR("7.4.9.0.b.1.c.c.f.e.3.9.9.9.1.0.0.0.0.0.c.1.2.e.2.c.6.5.0.8.d.f.ip6.arpa.")
+	if !ok || len(names) != 1 || names[0] != "node.home.lan." {
+		t.Fatalf("unexpected RFC4193 PTR ok=%v names=%v", ok, names)
+	}
+
+	zones := rc.ReverseZones()
+	want := []string{"17.147.10.in-addr.arpa.", "3.9.9.9.1.0.0.0.0.0.c.1.2.e.2.c.6.5.0.8.d.f.ip6.arpa."}
+	if len(zones) != len(want) || zones[0] != want[0] || zones[1] != want[1] {
+		t.Fatalf("unexpected reverse zones %v", zones)
+	}
+}
+
+func TestCacheRefreshNoReverse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := `[{"id":"efcc1b0947","name":"node","authorized":true,"ipAssignments":["10.147.17.2"]}]`
+		if r.URL.Path == "/api/v1/network/8056c2e21c000001/" {
+			body = `{"routes":[{"target":"10.147.17.0/24","via":null}]}`
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatalf("write response: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := &Config{Networks: []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}}, NoReverse: true}
+	rc := &RecordCache{}
+	if err := rc.refresh(context.Background(), NewClient(ts.URL, "token"), cfg); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+	if _, ok := rc.LookupPTR("2.17.147.10.in-addr.arpa."); ok {
+		t.Fatal("did not expect PTR record")
+	}
+	if zones := rc.ReverseZones(); len(zones) != 0 {
+		t.Fatalf("did not expect reverse zones, got %v", zones)
+	}
+}
+
+func TestCacheRefreshKeepsLastGoodPerNetwork(t *testing.T) {
+	var failing atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() && strings.Contains(r.URL.Path, "abcdef01234567aa") {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body := `{"v6AssignMode":{"6plane":false,"rfc4193":false}}`
+		if strings.HasSuffix(r.URL.Path, "/member/") {
+			body = `[{"id":"efcc1b0947","name":"node","authorized":true,"ipAssignments":["10.0.0.2"]}]`
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatalf("write response: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := &Config{Networks: []NetworkZone{
+		{Zone: "home.lan.", NetworkID: "8056c2e21c000001"},
+		{Zone: "work.lan.", NetworkID: "abcdef01234567aa"},
+	}}
+	c := NewClient(ts.URL, "token")
+	rc := &RecordCache{}
+	if err := rc.refresh(context.Background(), c, cfg); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+
+	failing.Store(true)
+	if err := rc.refresh(context.Background(), c, cfg); err == nil {
+		t.Fatal("expected refresh error for failing network")
+	}
+	if _, ok := rc.Lookup("node.home.lan."); !ok {
+		t.Fatal("expected records of healthy network")
+	}
+	if _, ok := rc.Lookup("node.work.lan."); !ok {
+		t.Fatal("expected stale records of failing network")
+	}
+	if st := rc.networks["abcdef01234567aa"]; st.failures != 1 {
+		t.Fatalf("expected failing network to be stale, got %d failures", st.failures)
+	}
+
+	cfg.MaxStale = time.Nanosecond
+	if err := rc.refresh(context.Background(), c, cfg); err == nil {
+		t.Fatal("expected refresh error for failing network")
+	}
+	if _, ok := rc.Lookup("node.work.lan."); ok {
+		t.Fatal("expected records older than max_stale to be dropped")
+	}
+	if _, ok := rc.Lookup("node.home.lan."); !ok {
+		t.Fatal("expected records of healthy network")
+	}
+
+	failing.Store(false)
+	if err := rc.refresh(context.Background(), c, cfg); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+	if _, ok := rc.Lookup("node.work.lan."); !ok {
+		t.Fatal("expected records after recovery")
+	}
+}
+
+func TestCacheRefreshBackoff(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	cfg := &Config{
+		Networks:   []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}},
+		RefreshTTL: time.Minute,
+		Backoff:    Backoff{Base: time.Hour, Max: time.Hour},
+	}
+	rc := &RecordCache{}
+	if err := rc.refresh(context.Background(), NewClient(ts.URL, "token"), cfg); err == nil {
+		t.Fatal("expected refresh error")
+	}
+	for range 2 {
+		if err := rc.refresh(context.Background(), NewClient(ts.URL, "token"), cfg); err != nil {
+			t.Fatalf("did not expect a refresh attempt while backing off, got %v", err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected a single API call while backing off, got %d", n)
+	}
+	if next := rc.nextRefresh(cfg); time.Until(next) > time.Minute {
+		t.Fatalf("expected next refresh to be capped by the refresh interval, got %s", next)
+	}
+}
+
+func TestCacheRefreshOnUpdate(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := `{}`
+		if strings.HasSuffix(r.URL.Path, "/member/") {
+			body = `[{"id":"efcc1b0947","name":"node","authorized":true,"ipAssignments":["10.0.0.2"]}]`
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatalf("write response: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := &Config{Networks: []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}}}
+	var updates [][]string
+	rc := &RecordCache{onUpdate: func(zones []string) { updates = append(updates, zones) }}
+	for range 2 {
+		if err := rc.refresh(context.Background(), NewClient(ts.URL, "token"), cfg); err != nil {
+			t.Fatalf("refresh error: %v", err)
+		}
+	}
+	if len(updates) != 1 || len(updates[0]) != 1 || updates[0][0] != "home.lan." {
+		t.Fatalf("expected a single update for home.lan., got %v", updates)
+	}
+}
+
+func TestCacheLookupWildcard(t *testing.T) {
+	records := &Records{
+		Hosts: map[string][]net.IP{
+			"alice-laptop.home.lan.":     {net.ParseIP("10.0.0.2")},
+			"api.alice-laptop.home.lan.": {net.ParseIP("10.0.0.9")},
+			"sub.home.lan.":              {net.ParseIP("10.0.0.3")},
+			"db.sub.home.lan.":           {net.ParseIP("10.0.1.4")},
+			"bob.work.lan.":              {net.ParseIP("10.1.0.2")},
+		},
+		TXT:   map[string][]string{"txt.alice-laptop.home.lan.": {"explicit"}},
+		Zones: []string{"home.lan.", "sub.home.lan.", "work.lan."},
+	}
+
+	rc := &RecordCache{}
+	rc.Replace(records)
+	if _, ok := rc.Lookup("www.alice-laptop.home.lan."); ok {
+		t.Fatal("did not expect wildcard match when disabled")
+	}
+
+	rc = &RecordCache{wildcard: true}
+	rc.Replace(records)
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"www.alice-laptop.home.lan.", "10.0.0.2"},
+		{"A.B.Alice-Laptop.HOME.lan.", "10.0.0.2"},
+		{"api.alice-laptop.home.lan.", "10.0.0.9"}, // explicit records win
+		{"v1.api.alice-laptop.home.lan.", "10.0.0.9"},
+		{"x.bob.work.lan.", "10.1.0.2"},
+		{"x.db.sub.home.lan.", "10.0.1.4"},
+		{"x.sub.home.lan.", ""}, // sub.home.lan. is the apex of its own zone
+		{"txt.alice-laptop.home.lan.", ""},
+		{"unknown.home.lan.", ""},
+		{"x.unknown.home.lan.", ""},
+	}
+	for _, tc := range tests {
+		ips, ok := rc.Lookup(tc.name)
+		if tc.want == "" {
+			if ok {
+				t.Errorf("%s: did not expect a match, got %v", tc.name, ips)
+			}
+			continue
+		}
+		if !ok || len(ips) != 1 || ips[0].String() != tc.want {
+			t.Errorf("%s: want %s, got %v", tc.name, tc.want, ips)
+		}
+	}
+	if !rc.Exists("www.alice-laptop.home.lan.") || rc.Exists("x.unknown.home.lan.") {
+		t.Fatal("unexpected existence of wildcard names")
+	}
+}
+
+func TestBuildIPv6Sources(t *testing.T) {
+	rfc4193, err := RFC4193("8056c2e21c000001", "efcc1b0947")
+	if err != nil {
+		t.Fatalf("RFC4193 error: %v", err)
+	}
+	rc := &RecordCache{networks: map[string]*networkState{
+		"8056c2e21c000001": {info: &NetworkInfo{RFC4193: true, SixPlane: true}, members: []Member{
+			{Authorized: true, ID: "efcc1b0947", Name: "node", IPs: []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("fd00:1::2"), rfc4193}},
+		}},
+	}}
+	networks := []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}}
+	cfg := &Config{Networks: networks}
+
+	// By default the assigned addresses are served, and each computed address once.
+	records := rc.build(cfg, networks, time.Now())
+	if ips := records.Hosts["node.home.lan."]; len(ips) != 4 || !ips[1].Equal(net.ParseIP("fd00:1::2")) || !ips[2].Equal(rfc4193) {
+		t.Fatalf("unexpected addresses %v", ips)
+	}
+	if len(records.ReverseZones) != 2 {
+		t.Fatalf("unexpected reverse zones %v", records.ReverseZones)
+	}
+
+	networks[0].IPv6 = &IPv6Sources{RFC4193: true}
+	records = rc.build(cfg, networks, time.Now())
+	if ips := records.Hosts["node.home.lan."]; len(ips) != 2 || !ips[0].Equal(net.ParseIP("10.0.0.2")) || !ips[1].Equal(rfc4193) {
+		t.Fatalf("unexpected addresses %v", ips)
+	}
+	if len(records.ReverseZones) != 1 || !strings.HasSuffix(records.ReverseZones[0], "d.f.ip6.arpa.") {
+		t.Fatalf("unexpected reverse zones %v", records.ReverseZones)
+	}
+	if len(rc.networks["8056c2e21c000001"].members[0].IPs) != 3 {
+		t.Fatal("expected cached member addresses to be left alone")
+	}
+}
+
+func TestCacheRefreshPerNetworkInterval(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := `{}`
+		if strings.HasSuffix(r.URL.Path, "/member/") {
+			body = `[]`
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatalf("write response: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := &Config{
+		Networks: []NetworkZone{
+			{Zone: "home.lan.", NetworkID: "8056c2e21c000001", RefreshTTL: 10 * time.Second},
+			{Zone: "office.lan.", NetworkID: "abcdef01234567aa"},
+		},
+		RefreshTTL: time.Hour,
+	}
+	rc := &RecordCache{}
+	if err := rc.refresh(context.Background(), NewClient(ts.URL, "token"), cfg); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+	if next := time.Until(rc.networks["8056c2e21c000001"].next); next > 10*time.Second {
+		t.Fatalf("expected next refresh of home.lan within 10s, got %s", next)
+	}
+	if next := time.Until(rc.networks["abcdef01234567aa"].next); next < 59*time.Minute {
+		t.Fatalf("expected next refresh of office.lan in an hour, got %s", next)
+	}
+	if next := time.Until(rc.nextRefresh(cfg)); next > 10*time.Second {
+		t.Fatalf("expected the refresh loop to wake up within 10s, got %s", next)
+	}
+}
@@ -0,0 +1,83 @@
+package ztnet
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DefaultCentralAddress is the address of the ZeroTier Central API.
+const DefaultCentralAddress = "https://api.zerotier.com"
+
+// CentralClient communicates with the ZeroTier Central API.
+type CentralClient struct {
+	baseURL    string
+	token      *tokenSource
+	httpClient *http.Client
+}
+
+// NewCentralClient returns a CentralClient with DefaultHTTPTimeout set.
+func NewCentralClient(baseURL, token string) *CentralClient {
+	return &CentralClient{baseURL: strings.TrimRight(baseURL, "/"), token: staticToken(token), httpClient: &http.Client{Timeout: DefaultHTTPTimeout}}
+}
+
+// centralNetworkResponse is a Central network, the settings are nested under config.
+type centralNetworkResponse struct {
+	Config networkInfoResponse `json:"config"`
+}
+
+// centralMemberResponse is a Central member. Its id is "<networkID>-<nodeID>", nodeId is the member ID
+// used elsewhere, and the controller settings are nested under config.
+type centralMemberResponse struct {
+	NodeID      string    `json:"nodeId"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	LastOnline  timestamp `json:"lastOnline"`
+	Config      struct {
+		Authorized    bool       `json:"authorized"`
+		IPAssignments []string   `json:"ipAssignments"`
+		Tags          memberTags `json:"tags"`
+	} `json:"config"`
+}
+
+// GetNetworkInfo fetches v6AssignMode and the managed routes for networkID.
+func (c *CentralClient) GetNetworkInfo(ctx context.Context, networkID string) (*NetworkInfo, error) {
+	url := fmt.Sprintf("%s/api/v1/network/%s", c.baseURL, networkID)
+	var response centralNetworkResponse
+	if err := c.getJSON(ctx, "network", networkID, url, &response); err != nil {
+		return nil, fmt.Errorf("ztnet: central: %w", err)
+	}
+	return response.Config.info(), nil
+}
+
+// GetMembers returns the members of networkID with their assigned IPv4 and IPv6 addresses.
+func (c *CentralClient) GetMembers(ctx context.Context, networkID string) ([]Member, error) {
+	url := fmt.Sprintf("%s/api/v1/network/%s/member", c.baseURL, networkID)
+	var response []centralMemberResponse
+	if err := c.getJSON(ctx, "members", networkID, url, &response); err != nil {
+		return nil, fmt.Errorf("ztnet: central: %w", err)
+	}
+
+	members := make([]Member, 0, len(response))
+	for _, m := range response {
+		r := memberResponse{
+			ID:            m.NodeID,
+			Name:          m.Name,
+			Description:   m.Description,
+			Authorized:    m.Config.Authorized,
+			IPAssignments: m.Config.IPAssignments,
+			LastSeen:      m.LastOnline,
+			Tags:          m.Config.Tags,
+		}
+		members = append(members, r.member())
+	}
+	return members, nil
+}
+
+func (c *CentralClient) getJSON(ctx context.Context, endpoint, networkID, url string, dst any) error {
+	return c.token.do(func(token string) error {
+		header := http.Header{"Authorization": {"Bearer " + token}}
+		return getJSON(ctx, c.httpClient, header, endpoint, networkID, url, dst)
+	})
+}
@@ -0,0 +1,100 @@
+package ztnet
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newCentralServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body string
+		switch r.URL.Path {
+		case "/api/v1/network/8056c2e21c000001":
+			body = `{"id":"8056c2e21c000001","config":{"v6AssignMode":{"6plane":true,"rfc4193":false},"routes":[{"target":"10.147.17.0/24","via":null}]}}`
+		case "/api/v1/network/8056c2e21c000001/member":
+			body = `[
+				{"id":"8056c2e21c000001-efcc1b0947","nodeId":"efcc1b0947","name":"node one","description":"laptop","lastOnline":1700000000000,
+				 "config":{"authorized":true,"ipAssignments":["10.147.17.2","fd80::1"],"tags":[[100,1]]}},
+				{"id":"8056c2e21c000001-deadbeef00","nodeId":"deadbeef00","name":"ignored","config":{"authorized":false,"ipAssignments":["10.147.17.3"]}}
+			]`
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Errorf("write response: %v", err)
+		}
+	}))
+}
+
+func TestCentralGetMembers(t *testing.T) {
+	ts := newCentralServer(t)
+	defer ts.Close()
+
+	c := NewCentralClient(ts.URL, "token")
+	members, err := c.GetMembers(context.Background(), "8056c2e21c000001")
+	if err != nil {
+		t.Fatalf("GetMembers error: %v", err)
+	}
+	if len(members) != 2 || members[0].ID != "efcc1b0947" || members[0].Name != "node one" || !members[0].Authorized || members[1].Authorized {
+		t.Fatalf("unexpected members %#v", members)
+	}
+	if len(members[0].IPs) != 2 || members[0].IPs[0].String() != "10.147.17.2" || members[0].IPs[1].String() != "fd80::1" {
+		t.Fatalf("unexpected IP list %#v", members[0].IPs)
+	}
+	if members[0].LastSeen.UnixMilli() != 1700000000000 || len(members[0].Tags) != 1 || members[0].Tags[0] != "100=1" {
+		t.Fatalf("unexpected last seen %v and tags %v", members[0].LastSeen, members[0].Tags)
+	}
+}
+
+func TestCentralGetNetworkInfo(t *testing.T) {
+	ts := newCentralServer(t)
+	defer ts.Close()
+
+	c := NewCentralClient(ts.URL, "token")
+	info, err := c.GetNetworkInfo(context.Background(), "8056c2e21c000001")
+	if err != nil {
+		t.Fatalf("GetNetworkInfo error: %v", err)
+	}
+	if !info.SixPlane || info.RFC4193 {
+		t.Fatalf("unexpected info %#v", info)
+	}
+	if len(info.Routes) != 1 || info.Routes[0].String() != "10.147.17.0/24" {
+		t.Fatalf("unexpected routes %v", info.Routes)
+	}
+}
+
+func TestCentralUnauthorized(t *testing.T) {
+	ts := newCentralServer(t)
+	defer ts.Close()
+
+	c := NewCentralClient(ts.URL, "wrong")
+	if _, err := c.GetNetworkInfo(context.Background(), "8056c2e21c000001"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestNetworkBackends(t *testing.T) {
+	central := newCentralServer(t)
+	defer central.Close()
+
+	b := &networkBackends{networks: map[string]Backend{"8056c2e21c000001": NewCentralClient(central.URL, "token")}}
+	rc := &RecordCache{}
+	cfg := &Config{Networks: []NetworkZone{{Zone: "cloud.lan.", NetworkID: "8056c2e21c000001", Backend: BackendCentral}}, RefreshTTL: DefaultRefreshTTL}
+	if err := rc.refresh(context.Background(), b, cfg); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+	if _, ok := rc.Lookup("node-one.cloud.lan."); !ok {
+		t.Fatal("expected record from Central")
+	}
+
+	if _, err := b.GetMembers(context.Background(), "abcdef01234567aa"); err == nil {
+		t.Fatal("expected error for network without backend")
+	}
+}
@@ -1,6 +1,15 @@
 package ztnet
 
-import "time"
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
 
 const (
 	// DefaultRefreshTTL is the default API polling interval.
@@ -9,19 +18,191 @@ const (
 	DefaultDNSTTL = 30 * time.Second
 	// DefaultHTTPTimeout is the default timeout for API HTTP calls.
 	DefaultHTTPTimeout = 10 * time.Second
+	// DefaultBackoffBase is the default retry delay after the first failed refresh of a network.
+	DefaultBackoffBase = 5 * time.Second
+	// DefaultBackoffMax is the default upper bound of the retry delay.
+	DefaultBackoffMax = 5 * time.Minute
+	// DefaultBackoffJitter is the default jitter fraction applied to the retry delay.
+	DefaultBackoffJitter = 0.2
+	// DefaultGroupsLabel is the default label group names are served below.
+	DefaultGroupsLabel = "groups"
+)
+
+const (
+	// BackendZTNET fetches networks from the ZTNET REST API.
+	BackendZTNET = "ztnet"
+	// BackendController fetches networks from the controller API of a zerotier-one service.
+	BackendController = "controller"
+	// BackendCentral fetches networks from the ZeroTier Central API.
+	BackendCentral = "central"
 )
 
 // Config holds all ztnet plugin configuration.
 type Config struct {
-	APIAddress string
-	APIToken   string
-	Networks   []NetworkZone
-	RefreshTTL time.Duration
-	DNSTTL     time.Duration
+	// Backend is the API networks are fetched from by default, BackendZTNET or BackendController.
+	// APIAddress and APIToken belong to this backend. APITokenFile is read instead of APIToken when set.
+	Backend      string
+	APIAddress   string
+	APIToken     string
+	APITokenFile string
+	// TLS is the client TLS configuration of the default backend, nil means the system defaults. Central
+	// is always reached with the system roots.
+	TLS *tls.Config
+	// Proxy is the HTTP proxy for all API requests, nil means the proxy from the environment.
+	Proxy *url.URL
+	// HTTPTimeout is the timeout of API requests.
+	HTTPTimeout time.Duration
+	// Org scopes the ZTNET backend to an organization, empty means the personal networks of the token.
+	Org string
+	// Discover names the zone of every network listed by the ZTNET backend, nil disables discovery.
+	// Discovered networks are served next to Networks.
+	Discover *template.Template
+	// CentralAddress and CentralToken are used for networks served from ZeroTier Central.
+	CentralAddress   string
+	CentralToken     string
+	CentralTokenFile string
+	Networks         []NetworkZone
+	RefreshTTL       time.Duration
+	DNSTTL           time.Duration
+	// ReverseZones are reverse zones served in addition to the ones derived from network data.
+	ReverseZones []string
+	// NoReverse disables PTR records and reverse zones altogether.
+	NoReverse bool
+	// Backoff configures retries of networks whose refresh failed.
+	Backoff Backoff
+	// MaxStale is how long the last-good records of a failing network are served, zero means forever.
+	MaxStale time.Duration
+	// WebhookAddr is the listen address of the ZTNET webhook receiver, empty disables it.
+	WebhookAddr string
+	// WebhookSecret authenticates webhook requests, as bearer token or HMAC-SHA256 signature.
+	WebhookSecret string
+	// Names are the templates of the names of every member, relative to the zone. Empty means the
+	// member name and ID. Networks can override them.
+	Names []*template.Template
+	// IPv6 selects the IPv6 addresses that are served, nil means all of them. Networks can override it.
+	IPv6 *IPv6Sources
+	// IDNA converts unicode member names to punycode instead of dropping the unicode characters.
+	IDNA bool
+	// Duplicates is the policy for members of a zone whose names map to the same label, one of
+	// DuplicateMerge, DuplicateFirst, DuplicateSuffix or DuplicateSkip.
+	Duplicates string
+	// Filter selects the members that are served.
+	Filter MemberFilter
+	// MembersOnly makes the zones of every network only answer clients that belong to the network.
+	MembersOnly bool
+	// Wildcard makes every name below a member name resolve to the addresses of the member.
+	Wildcard bool
+	// Groups serves a name per member group below this label of the zone, resolving to the addresses of
+	// all members in the group. Empty disables group names.
+	Groups string
+	// GroupTags maps group names to the tags of their members, as "<id>" or "<id>=<value>". Members join
+	// groups with their description as well, see parseMemberRecords.
+	GroupTags map[string][]string
+	// UnhealthyAfter makes the plugin report not ready when the last successful sync is older, zero disables it.
+	UnhealthyAfter time.Duration
+	// Snapshot is the file the last-good network data is written to after every successful refresh and
+	// loaded from at startup, empty disables it.
+	Snapshot string
+	// SnapshotMaxAge ignores snapshots written longer ago at startup, zero means any age.
+	SnapshotMaxAge time.Duration
 }
 
 // NetworkZone pairs a DNS zone with a ZeroTier network ID.
 type NetworkZone struct {
 	Zone      string
 	NetworkID string
+	// Backend is the API the network is fetched from, empty means the default backend.
+	Backend string
+	// APIAddress and APIToken (or APITokenFile) are the endpoint and token of the backend for this network,
+	// setup fills in the ones of the backend when the network does not have its own.
+	APIAddress   string
+	APIToken     string
+	APITokenFile string
+	// RefreshTTL and DNSTTL override the ones of Config for this network when not zero.
+	RefreshTTL time.Duration
+	DNSTTL     time.Duration
+	// Names overrides Config.Names for the members of this network.
+	Names []*template.Template
+	// IPv6 overrides Config.IPv6 for the members of this network.
+	IPv6 *IPv6Sources
+	// MembersOnly makes the zone only answer clients that belong to this network.
+	MembersOnly bool
+}
+
+// IPv6Sources selects the IPv6 addresses of members that are served.
+type IPv6Sources struct {
+	// Assigned serves the IPv6 addresses assigned to members, like the ones from ZTNET address pools.
+	Assigned bool
+	// RFC4193 and SixPlane serve the computed addresses of networks that enable the mode.
+	RFC4193  bool
+	SixPlane bool
+}
+
+// configured reports whether networkID is configured with network, as opposed to discovered.
+func (cfg *Config) configured(networkID string) bool {
+	return slices.ContainsFunc(cfg.Networks, func(nz NetworkZone) bool { return nz.NetworkID == networkID })
+}
+
+// endpoint returns the backend, address and credentials networkID is fetched with. The credentials are
+// the token file, or a hash of the token, and the organization. Networks that are not configured are
+// fetched from the default backend.
+func (cfg *Config) endpoint(networkID string) string {
+	backend, address, token, tokenFile := cfg.Backend, cfg.APIAddress, cfg.APIToken, cfg.APITokenFile
+	for _, nz := range cfg.Networks {
+		if nz.NetworkID == networkID {
+			backend, address, token, tokenFile = nz.Backend, nz.APIAddress, nz.APIToken, nz.APITokenFile
+			break
+		}
+	}
+	credentials := "file:" + tokenFile
+	if tokenFile == "" {
+		credentials = fmt.Sprintf("token:%x", sha256.Sum256([]byte(token)))
+	}
+	return strings.Join([]string{backend, address, credentials, cfg.org(backend, address)}, " ")
+}
+
+// org returns the organization the networks of backend at address are fetched in. The organization
+// belongs to the default endpoint, networks with an endpoint of their own are not scoped to it.
+func (cfg *Config) org(backend, address string) string {
+	if backend != BackendZTNET || address != cfg.APIAddress {
+		return ""
+	}
+	return cfg.Org
+}
+
+// refreshTTL returns the polling interval of nz.
+func (cfg *Config) refreshTTL(nz NetworkZone) time.Duration {
+	if nz.RefreshTTL > 0 {
+		return nz.RefreshTTL
+	}
+	return cfg.RefreshTTL
+}
+
+// dnsTTL returns the TTL of the records in zone. When networks sharing a zone have their own TTL, the
+// lowest one is used.
+func (cfg *Config) dnsTTL(zone string) time.Duration {
+	var ttl time.Duration
+	for _, nz := range cfg.Networks {
+		if nz.Zone == zone && nz.DNSTTL > 0 && (ttl == 0 || nz.DNSTTL < ttl) {
+			ttl = nz.DNSTTL
+		}
+	}
+	if ttl == 0 {
+		return cfg.DNSTTL
+	}
+	return ttl
+}
+
+// membersOnly reports whether the zone of nz only answers clients that belong to the network.
+func (cfg *Config) membersOnly(nz NetworkZone) bool { return cfg.MembersOnly || nz.MembersOnly }
+
+// ipv6Sources returns the IPv6 sources of the members of nz.
+func (cfg *Config) ipv6Sources(nz NetworkZone) IPv6Sources {
+	switch {
+	case nz.IPv6 != nil:
+		return *nz.IPv6
+	case cfg.IPv6 != nil:
+		return *cfg.IPv6
+	}
+	return IPv6Sources{Assigned: true, RFC4193: true, SixPlane: true}
 }
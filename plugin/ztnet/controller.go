@@ -0,0 +1,72 @@
+package ztnet
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	// DefaultControllerAddress is the default address of the local zerotier-one service API.
+	DefaultControllerAddress = "http://localhost:9993"
+	// DefaultControllerTokenFile is the default location of the zerotier-one service API token.
+	DefaultControllerTokenFile = "/var/lib/zerotier-one/authtoken.secret"
+)
+
+// ControllerClient communicates with the network controller of a zerotier-one service.
+type ControllerClient struct {
+	baseURL    string
+	token      *tokenSource
+	httpClient *http.Client
+}
+
+// NewControllerClient returns a ControllerClient with DefaultHTTPTimeout set.
+func NewControllerClient(baseURL, token string) *ControllerClient {
+	return &ControllerClient{baseURL: strings.TrimRight(baseURL, "/"), token: staticToken(token), httpClient: &http.Client{Timeout: DefaultHTTPTimeout}}
+}
+
+// GetNetworkInfo fetches v6AssignMode and the managed routes for networkID.
+func (c *ControllerClient) GetNetworkInfo(ctx context.Context, networkID string) (*NetworkInfo, error) {
+	url := fmt.Sprintf("%s/controller/network/%s", c.baseURL, networkID)
+	var response networkInfoResponse
+	if err := c.getJSON(ctx, "network", networkID, url, &response); err != nil {
+		return nil, fmt.Errorf("ztnet: controller: %w", err)
+	}
+	return response.info(), nil
+}
+
+// GetMembers returns the members of networkID. The controller only lists member IDs, so every member
+// is fetched on its own. The controller does not know when a member was last online.
+func (c *ControllerClient) GetMembers(ctx context.Context, networkID string) ([]Member, error) {
+	url := fmt.Sprintf("%s/controller/network/%s/member", c.baseURL, networkID)
+	var revisions map[string]any
+	if err := c.getJSON(ctx, "members", networkID, url, &revisions); err != nil {
+		return nil, fmt.Errorf("ztnet: controller: %w", err)
+	}
+
+	ids := make([]string, 0, len(revisions))
+	for id := range revisions {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	members := make([]Member, 0, len(ids))
+	for _, id := range ids {
+		var response memberResponse
+		if err := c.getJSON(ctx, "member", networkID, url+"/"+id, &response); err != nil {
+			return nil, fmt.Errorf("ztnet: controller: member %s: %w", id, err)
+		}
+		members = append(members, response.member())
+	}
+	return members, nil
+}
+
+func (c *ControllerClient) getJSON(ctx context.Context, endpoint, networkID, url string, dst any) error {
+	return c.token.do(func(token string) error {
+		header := http.Header{}
+		header.Set("X-ZT1-Auth", token)
+		return getJSON(ctx, c.httpClient, header, endpoint, networkID, url, dst)
+	})
+}
@@ -0,0 +1,106 @@
+package ztnet
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newControllerServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-ZT1-Auth") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body string
+		switch r.URL.Path {
+		case "/controller/network/8056c2e21c000001":
+			body = `{"id":"8056c2e21c000001","v6AssignMode":{"6plane":false,"rfc4193":true},"routes":[{"target":"10.147.17.0/24","via":null}]}`
+		case "/controller/network/8056c2e21c000001/member":
+			body = `{"efcc1b0947":3,"deadbeef00":1}`
+		case "/controller/network/8056c2e21c000001/member/efcc1b0947":
+			body = `{"id":"efcc1b0947","authorized":true,"ipAssignments":["10.147.17.2"]}`
+		case "/controller/network/8056c2e21c000001/member/deadbeef00":
+			body = `{"id":"deadbeef00","authorized":false,"ipAssignments":["10.147.17.3"]}`
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Errorf("write response: %v", err)
+		}
+	}))
+}
+
+func TestControllerGetMembers(t *testing.T) {
+	ts := newControllerServer(t)
+	defer ts.Close()
+
+	c := NewControllerClient(ts.URL, "token")
+	members, err := c.GetMembers(context.Background(), "8056c2e21c000001")
+	if err != nil {
+		t.Fatalf("GetMembers error: %v", err)
+	}
+	// Members are fetched in ID order.
+	if len(members) != 2 || members[1].ID != "efcc1b0947" || members[1].Name != "" || !members[1].Authorized || members[0].Authorized {
+		t.Fatalf("unexpected members %#v", members)
+	}
+	if len(members[1].IPs) != 1 || members[1].IPs[0].String() != "10.147.17.2" {
+		t.Fatalf("unexpected IP list %#v", members[1].IPs)
+	}
+}
+
+func TestControllerGetNetworkInfo(t *testing.T) {
+	ts := newControllerServer(t)
+	defer ts.Close()
+
+	c := NewControllerClient(ts.URL, "token")
+	info, err := c.GetNetworkInfo(context.Background(), "8056c2e21c000001")
+	if err != nil {
+		t.Fatalf("GetNetworkInfo error: %v", err)
+	}
+	if !info.RFC4193 || info.SixPlane || len(info.Routes) != 1 {
+		t.Fatalf("unexpected info %#v", info)
+	}
+}
+
+func TestControllerUnauthorized(t *testing.T) {
+	ts := newControllerServer(t)
+	defer ts.Close()
+
+	c := NewControllerClient(ts.URL, "wrong")
+	if _, err := c.GetMembers(context.Background(), "8056c2e21c000001"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestControllerRecords(t *testing.T) {
+	ts := newControllerServer(t)
+	defer ts.Close()
+
+	rc := &RecordCache{}
+	cfg := &Config{Networks: []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}}, RefreshTTL: DefaultRefreshTTL, NoReverse: true}
+	if err := rc.refresh(context.Background(), NewControllerClient(ts.URL, "token"), cfg); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+	if ips, ok := rc.Lookup("efcc1b0947.home.lan."); !ok || len(ips) != 2 {
+		t.Fatalf("unexpected lookup %v %v", ips, ok)
+	}
+	if rc.Exists(".home.lan.") {
+		t.Fatal("unexpected record for empty member name")
+	}
+}
+
+func TestReadTokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authtoken.secret")
+	if err := os.WriteFile(path, []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	token, err := readTokenFile(path)
+	if err != nil || token != "s3cr3t" {
+		t.Fatalf("got %q %v", token, err)
+	}
+}
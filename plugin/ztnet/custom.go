@@ -0,0 +1,140 @@
+package ztnet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/miekg/dns"
+)
+
+// customPrefix starts the custom records in a member description.
+const customPrefix = "dns:"
+
+// memberRecords are the custom records of a member.
+type memberRecords struct {
+	// Aliases are names relative to the zone that get a CNAME to the member.
+	Aliases []string
+	// TXT are the strings of TXT records at the member name.
+	TXT []string
+	// SRV are services that get an SRV record relative to the zone, targeting the member.
+	SRV []memberService
+	// Groups are the groups the member joins, as single labels.
+	Groups []string
+}
+
+// memberService is a service offered by a member, like _ssh._tcp on port 22.
+type memberService struct {
+	Service  string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+}
+
+// parseMemberRecords parses the custom records in a member description. They follow "dns:" up to the
+// end of the line, as entries separated by semicolons:
+//
+//	dns: alias=gitlab,ssh; txt=build runner; srv=_ssh._tcp:22,_https._tcp:443:10:5; group=web,db
+//
+// Aliases and groups are sanitized like member names, SRV values are SERVICE:PORT[:PRIORITY[:WEIGHT]]. Invalid
+// entries are skipped and returned as error, the valid ones are returned regardless.
+func parseMemberRecords(description string, useIDNA bool) (memberRecords, error) {
+	var mr memberRecords
+	i := strings.Index(strings.ToLower(description), customPrefix)
+	if i < 0 {
+		return mr, nil
+	}
+	section, _, _ := strings.Cut(description[i+len(customPrefix):], "\n")
+
+	var errs []error
+	for entry := range strings.SplitSeq(section, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			errs = append(errs, fmt.Errorf("entry %q is not key=value", entry))
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "alias":
+			for alias := range strings.SplitSeq(value, ",") {
+				name, err := aliasName(alias, useIDNA)
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				mr.Aliases = append(mr.Aliases, name)
+			}
+		case "txt":
+			// A TXT string holds at most 255 bytes, longer values are cut at the start of a character.
+			if len(value) > 255 {
+				n := 255
+				for n > 0 && !utf8.RuneStart(value[n]) {
+					n--
+				}
+				value = value[:n]
+			}
+			mr.TXT = append(mr.TXT, value)
+		case "srv":
+			for srv := range strings.SplitSeq(value, ",") {
+				s, err := parseService(strings.TrimSpace(srv))
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				mr.SRV = append(mr.SRV, s)
+			}
+		case "group":
+			for group := range strings.SplitSeq(value, ",") {
+				label := sanitizeLabel(group, useIDNA)
+				if label == "" {
+					errs = append(errs, fmt.Errorf("invalid group %q", group))
+					continue
+				}
+				mr.Groups = append(mr.Groups, label)
+			}
+		default:
+			errs = append(errs, fmt.Errorf("unknown record type %q", key))
+		}
+	}
+	return mr, errors.Join(errs...)
+}
+
+// aliasName sanitizes every label of alias.
+func aliasName(alias string, useIDNA bool) (string, error) {
+	labels := strings.Split(strings.Trim(strings.TrimSpace(alias), "."), ".")
+	for i, l := range labels {
+		labels[i] = sanitizeLabel(l, useIDNA)
+		if labels[i] == "" {
+			return "", fmt.Errorf("invalid alias %q", alias)
+		}
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// parseService parses SERVICE:PORT[:PRIORITY[:WEIGHT]], where SERVICE is like _ssh._tcp.
+func parseService(s string) (memberService, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 4 {
+		return memberService{}, fmt.Errorf("invalid service %q, must be SERVICE:PORT[:PRIORITY[:WEIGHT]]", s)
+	}
+	service := strings.ToLower(parts[0])
+	labels := dns.SplitDomainName(service)
+	if len(labels) != 2 || len(labels[0]) < 2 || len(labels[1]) < 2 || labels[0][0] != '_' || labels[1][0] != '_' {
+		return memberService{}, fmt.Errorf("invalid service name %q, must be like _ssh._tcp", parts[0])
+	}
+	ms := memberService{Service: service}
+	for i, dst := range []*uint16{&ms.Port, &ms.Priority, &ms.Weight}[:len(parts)-1] {
+		n, err := strconv.ParseUint(parts[i+1], 10, 16)
+		if err != nil {
+			return memberService{}, fmt.Errorf("invalid number %q in service %q", parts[i+1], s)
+		}
+		*dst = uint16(n)
+	}
+	return ms, nil
+}
@@ -0,0 +1,124 @@
+package ztnet
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMemberRecords(t *testing.T) {
+	mr, err := parseMemberRecords("Build runner\ndns: alias=gitlab, Git Lab,ci.svc; txt=owner=alice; srv=_ssh._tcp:22,_https._tcp:443:10:5\nmore text", false)
+	if err != nil {
+		t.Fatalf("parseMemberRecords error: %v", err)
+	}
+	if len(mr.Aliases) != 3 || mr.Aliases[0] != "gitlab" || mr.Aliases[1] != "git-lab" || mr.Aliases[2] != "ci.svc" {
+		t.Fatalf("unexpected aliases %v", mr.Aliases)
+	}
+	if len(mr.TXT) != 1 || mr.TXT[0] != "owner=alice" {
+		t.Fatalf("unexpected TXT %v", mr.TXT)
+	}
+	want := []memberService{{Service: "_ssh._tcp", Port: 22}, {Service: "_https._tcp", Port: 443, Priority: 10, Weight: 5}}
+	if len(mr.SRV) != len(want) || mr.SRV[0] != want[0] || mr.SRV[1] != want[1] {
+		t.Fatalf("unexpected SRV %v", mr.SRV)
+	}
+
+	mr, err = parseMemberRecords("dns: group=web, Web Servers", false)
+	if err != nil || len(mr.Groups) != 2 || mr.Groups[0] != "web" || mr.Groups[1] != "web-servers" {
+		t.Fatalf("unexpected groups %v, error %v", mr.Groups, err)
+	}
+
+	if mr, err := parseMemberRecords("just a laptop", false); err != nil || len(mr.Aliases)+len(mr.TXT)+len(mr.SRV) != 0 {
+		t.Fatalf("expected no records, got %v %v", mr, err)
+	}
+
+	// Long values are cut to 255 bytes without splitting a character, "ü" takes two bytes.
+	mr, err = parseMemberRecords("dns: txt="+strings.Repeat("ü", 200), false)
+	if err != nil || len(mr.TXT) != 1 || mr.TXT[0] != strings.Repeat("ü", 127) {
+		t.Fatalf("unexpected TXT %q, error %v", mr.TXT, err)
+	}
+}
+
+func TestParseMemberRecordsInvalid(t *testing.T) {
+	for _, desc := range []string{
+		"dns: alias=!!!",
+		"dns: srv=_ssh._tcp",
+		"dns: srv=ssh.tcp:22",
+		"dns: srv=_ssh._tcp:99999",
+		"dns: mx=mail",
+		"dns: alias",
+		"dns: group=!!!",
+	} {
+		if _, err := parseMemberRecords(desc, false); err == nil {
+			t.Errorf("expected error for %q", desc)
+		}
+	}
+
+	// Valid entries are kept next to invalid ones.
+	mr, err := parseMemberRecords("dns: alias=web,!!!; srv=_http._tcp:80", false)
+	if err == nil || len(mr.Aliases) != 1 || len(mr.SRV) != 1 {
+		t.Fatalf("unexpected result %v %v", mr, err)
+	}
+}
+
+func TestBuildMemberRecords(t *testing.T) {
+	rc := &RecordCache{networks: map[string]*networkState{
+		"8056c2e21c000001": {info: &NetworkInfo{}, members: []Member{
+			{Authorized: true, ID: "aaaaaaaaaa", Name: "server", IPs: []net.IP{net.ParseIP("10.0.0.1")}, Description: "dns: alias=gitlab,laptop; txt=rack 4; srv=_ssh._tcp:22"},
+			{Authorized: true, ID: "bbbbbbbbbb", Name: "laptop", IPs: []net.IP{net.ParseIP("10.0.0.2")}, Description: "dns: srv=_ssh._tcp:2222"},
+		}},
+	}}
+	networks := []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}}
+	records := rc.build(&Config{Networks: networks, NoReverse: true}, networks, time.Now())
+
+	if target := records.CNAME["gitlab.home.lan."]; target != "server.home.lan." {
+		t.Fatalf("unexpected CNAME target %q", target)
+	}
+	if _, ok := records.CNAME["laptop.home.lan."]; ok {
+		t.Fatal("did not expect alias shadowing a member name")
+	}
+	if txt := records.TXT["server.home.lan."]; len(txt) != 1 || txt[0] != "rack 4" {
+		t.Fatalf("unexpected TXT %v", txt)
+	}
+	if srv := records.SRV["_ssh._tcp.home.lan."]; len(srv) != 2 || srv[0].Target != "server.home.lan." || srv[1].Port != 2222 {
+		t.Fatalf("unexpected SRV %v", srv)
+	}
+}
+
+func TestBuildGroups(t *testing.T) {
+	rc := &RecordCache{networks: map[string]*networkState{
+		"8056c2e21c000001": {info: &NetworkInfo{}, members: []Member{
+			{Authorized: true, ID: "aaaaaaaaaa", Name: "web1", IPs: []net.IP{net.ParseIP("10.0.0.1")}, Description: "dns: group=web,db"},
+			{Authorized: true, ID: "bbbbbbbbbb", Name: "web2", IPs: []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("fd00::2")}, Tags: []string{"100=1"}},
+			{Authorized: true, ID: "cccccccccc", Name: "web3", IPs: []net.IP{net.ParseIP("10.0.0.3")}, Tags: []string{"100=2"}, Description: "dns: group=web"},
+			{Authorized: false, ID: "dddddddddd", Name: "new", IPs: []net.IP{net.ParseIP("10.0.0.4")}, Description: "dns: group=web"},
+		}},
+	}}
+	networks := []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}}
+	cfg := &Config{Networks: networks, Groups: DefaultGroupsLabel, GroupTags: map[string][]string{"web": {"100"}}, Filter: MemberFilter{Pending: "pending"}}
+	records := rc.build(cfg, networks, time.Now())
+
+	want := []string{"10.0.0.1", "10.0.0.2", "fd00::2", "10.0.0.3"}
+	ips := records.Hosts["web.groups.home.lan."]
+	if len(ips) != len(want) {
+		t.Fatalf("want %v, got %v", want, ips)
+	}
+	for i, ip := range ips {
+		if ip.String() != want[i] {
+			t.Fatalf("want %v, got %v", want, ips)
+		}
+	}
+	if ips := records.Hosts["db.groups.home.lan."]; len(ips) != 1 || ips[0].String() != "10.0.0.1" {
+		t.Fatalf("unexpected addresses for db.groups.home.lan.: %v", ips)
+	}
+	if len(records.PTR["1.0.0.10.in-addr.arpa."]) != 1 {
+		t.Fatalf("did not expect PTR records for groups: %v", records.PTR["1.0.0.10.in-addr.arpa."])
+	}
+
+	// Without groups the description entries are ignored.
+	cfg.Groups = ""
+	records = rc.build(cfg, networks, time.Now())
+	if _, ok := records.Hosts["web.groups.home.lan."]; ok {
+		t.Fatal("did not expect group names without groups")
+	}
+}
@@ -0,0 +1,115 @@
+package ztnet
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// zoneData is the data the discover template is executed with.
+type zoneData struct {
+	NetworkID   string
+	NetworkName string
+}
+
+// parseZoneTemplate parses a discover template and checks it yields a valid zone.
+func parseZoneTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("zone").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := discoverZone(tmpl, Network{ID: "8056c2e21c000001", Name: "example"}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// discoverZone returns the zone of network n. The network name is turned into a single DNS label, a
+// network without a usable name gets its ID instead.
+func discoverZone(tmpl *template.Template, n Network) (string, error) {
+	data := zoneData{NetworkID: n.ID, NetworkName: sanitizeLabel(n.Name, false)}
+	if data.NetworkName == "" {
+		data.NetworkName = n.ID
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	zone := strings.TrimSuffix(strings.ToLower(sb.String()), ".")
+	if !zoneRegex.MatchString(zone) {
+		return "", fmt.Errorf("invalid zone name %q", zone)
+	}
+	return zone + ".", nil
+}
+
+// networkZones returns the configured networks followed by the discovered ones. Discovery runs once
+// per refresh interval, when it fails the previously discovered networks are kept.
+func (rc *RecordCache) networkZones(ctx context.Context, b Backend, cfg *Config, now time.Time) ([]NetworkZone, error) {
+	if cfg.Discover == nil {
+		return cfg.Networks, nil
+	}
+	var err error
+	if !now.Before(rc.discoverNext) {
+		rc.discoverNext = now.Add(cfg.RefreshTTL)
+		if err = rc.discover(ctx, b, cfg); err == nil {
+			rc.discovered, rc.discoverSynced = now, now
+		}
+	}
+	return slices.Concat(cfg.Networks, rc.discoveredNetworks), err
+}
+
+// discover lists the networks of the backend and names their zones. Networks that are configured
+// explicitly keep their configured zone.
+func (rc *RecordCache) discover(ctx context.Context, b Backend, cfg *Config) error {
+	d, ok := b.(Discoverer)
+	if !ok {
+		return fmt.Errorf("ztnet: cache: discover: backend does not support network discovery")
+	}
+	networks, err := d.ListNetworks(ctx)
+	if err != nil {
+		return fmt.Errorf("ztnet: cache: discover: %w", err)
+	}
+	slices.SortFunc(networks, func(a, b Network) int { return strings.Compare(a.ID, b.ID) })
+
+	ids := make(map[string]struct{})
+	zones := make(map[string]string)
+	for _, nz := range cfg.Networks {
+		ids[nz.NetworkID] = struct{}{}
+		zones[nz.Zone] = nz.NetworkID
+	}
+	prev := make(map[string]string)
+	for _, nz := range rc.discoveredNetworks {
+		prev[nz.NetworkID] = nz.Zone
+	}
+
+	var discovered []NetworkZone
+	for _, n := range networks {
+		if _, ok := ids[n.ID]; ok {
+			continue
+		}
+		ids[n.ID] = struct{}{}
+		zone, err := discoverZone(cfg.Discover, n)
+		if err != nil {
+			log.Warningf("Skipping discovered network %s: %v", n.ID, err)
+			continue
+		}
+		if other, ok := zones[zone]; ok {
+			log.Warningf("Skipping discovered network %s, zone %s is already used by network %s", n.ID, zone, other)
+			continue
+		}
+		zones[zone] = n.ID
+		if prev[n.ID] != zone {
+			log.Infof("Discovered network %s, serving it as %s", n.ID, zone)
+		}
+		delete(prev, n.ID)
+		discovered = append(discovered, NetworkZone{Zone: zone, NetworkID: n.ID, Backend: cfg.Backend})
+	}
+	for id, zone := range prev {
+		log.Infof("Network %s is gone, no longer serving %s", id, zone)
+	}
+	rc.discoveredNetworks = discovered
+	return nil
+}
@@ -0,0 +1,167 @@
+package ztnet
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiscoverZone(t *testing.T) {
+	tmpl, err := parseZoneTemplate("{{.NetworkName}}.zt.example.")
+	if err != nil {
+		t.Fatalf("parseZoneTemplate error: %v", err)
+	}
+	zone, err := discoverZone(tmpl, Network{ID: "8056c2e21c000001", Name: "Home Lab"})
+	if err != nil || zone != "home-lab.zt.example." {
+		t.Fatalf("unexpected zone %q %v", zone, err)
+	}
+	zone, err = discoverZone(tmpl, Network{ID: "8056c2e21c000001", Name: "!!"})
+	if err != nil || zone != "8056c2e21c000001.zt.example." {
+		t.Fatalf("expected network ID for unusable name, got %q %v", zone, err)
+	}
+
+	for _, text := range []string{"{{.Unknown}}.zt.example.", "{{.NetworkName", "{{.NetworkName}}_zt"} {
+		if _, err := parseZoneTemplate(text); err == nil {
+			t.Errorf("expected error for %q", text)
+		}
+	}
+}
+
+func TestClientOrgURLs(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		paths []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		body := `{}`
+		if r.URL.Path != "/api/v1/org/org1/network/8056c2e21c000001/" {
+			body = `[]`
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Errorf("write response: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	c := NewClient(ts.URL, "token")
+	c.org = "org1"
+	if _, err := c.ListNetworks(context.Background()); err != nil {
+		t.Fatalf("ListNetworks error: %v", err)
+	}
+	if _, err := c.GetNetworkInfo(context.Background(), "8056c2e21c000001"); err != nil {
+		t.Fatalf("GetNetworkInfo error: %v", err)
+	}
+	if _, err := c.GetMembers(context.Background(), "8056c2e21c000001"); err != nil {
+		t.Fatalf("GetMembers error: %v", err)
+	}
+	want := []string{"/api/v1/org/org1/network/", "/api/v1/org/org1/network/8056c2e21c000001/", "/api/v1/org/org1/network/8056c2e21c000001/member/"}
+	if len(paths) != len(want) {
+		t.Fatalf("want paths %v, got %v", want, paths)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Fatalf("want paths %v, got %v", want, paths)
+		}
+	}
+}
+
+func TestCacheRefreshDiscover(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		networks = `[{"nwid":"8056c2e21c000001","name":"Home Lab"},{"nwid":"abcdef01234567aa","name":"office"},{"nwid":"1111111111111111","name":"static"}]`
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body string
+		switch r.URL.Path {
+		case "/api/v1/network/":
+			mu.Lock()
+			body = networks
+			mu.Unlock()
+		case "/api/v1/network/8056c2e21c000001/member/", "/api/v1/network/abcdef01234567aa/member/", "/api/v1/network/1111111111111111/member/":
+			body = `[{"id":"efcc1b0947","name":"node","authorized":true,"ipAssignments":["10.0.0.2"]}]`
+		default:
+			body = `{}`
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Errorf("write response: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	tmpl, err := parseZoneTemplate("{{.NetworkName}}.zt.example.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		Networks:   []NetworkZone{{Zone: "static.lan.", NetworkID: "1111111111111111"}},
+		Discover:   tmpl,
+		RefreshTTL: time.Minute,
+		NoReverse:  true,
+	}
+	rc := &RecordCache{}
+	if err := rc.refresh(context.Background(), NewClient(ts.URL, "token"), cfg); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+	for _, name := range []string{"node.home-lab.zt.example.", "node.office.zt.example.", "node.static.lan."} {
+		if _, ok := rc.Lookup(name); !ok {
+			t.Errorf("expected record for %s", name)
+		}
+	}
+	if _, ok := rc.Lookup("node.static.zt.example."); ok {
+		t.Error("did not expect a discovered zone for a configured network")
+	}
+	if rc.LastSync().IsZero() {
+		t.Error("expected last sync after discovery")
+	}
+
+	// The office network is deleted, discovery runs again once it is due.
+	mu.Lock()
+	networks = `[{"nwid":"8056c2e21c000001","name":"Home Lab"}]`
+	mu.Unlock()
+	if err := rc.refresh(context.Background(), NewClient(ts.URL, "token"), cfg); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+	if _, ok := rc.Lookup("node.office.zt.example."); !ok {
+		t.Error("did not expect discovery before it is due")
+	}
+	rc.Trigger("abcdef01234567ab", time.Now())
+	rc.schedulePending()
+	if err := rc.refresh(context.Background(), NewClient(ts.URL, "token"), cfg); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+	if _, ok := rc.Lookup("node.office.zt.example."); ok {
+		t.Error("expected records of deleted network to be gone")
+	}
+	if _, ok := rc.networks["abcdef01234567aa"]; ok {
+		t.Error("expected state of deleted network to be dropped")
+	}
+	if zones := rc.Zones(); len(zones) != 2 {
+		t.Errorf("unexpected zones %v", zones)
+	}
+}
+
+func TestCacheRefreshDiscoverFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	tmpl, err := parseZoneTemplate("{{.NetworkName}}.zt.example.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := &RecordCache{}
+	cfg := &Config{Discover: tmpl, RefreshTTL: time.Minute}
+	if err := rc.refresh(context.Background(), NewClient(ts.URL, "token"), cfg); err == nil {
+		t.Fatal("expected discovery error")
+	}
+	if !rc.LastSync().IsZero() {
+		t.Fatal("did not expect a sync before the first discovery")
+	}
+}
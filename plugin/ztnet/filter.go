@@ -0,0 +1,50 @@
+package ztnet
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MemberFilter selects the members that are served.
+type MemberFilter struct {
+	// OnlineWithin excludes members not seen online for longer, zero disables it. Members of backends
+	// that do not report when a member was last seen are kept.
+	OnlineWithin time.Duration
+	// Pending serves unauthorized members below this label of the zone, empty excludes them.
+	Pending string
+	// ExcludeTags excludes members with one of these tags, as "<id>" or "<id>=<value>".
+	ExcludeTags []string
+	// ExcludeName excludes members whose name matches, nil disables it.
+	ExcludeName *regexp.Regexp
+}
+
+// match reports whether m is served, and if so whether it is served as pending member.
+func (f *MemberFilter) match(m Member, now time.Time) (serve, pending bool) {
+	if !m.Authorized && f.Pending == "" {
+		return false, false
+	}
+	if f.OnlineWithin > 0 && !m.LastSeen.IsZero() && now.Sub(m.LastSeen) > f.OnlineWithin {
+		return false, false
+	}
+	if f.ExcludeName != nil && f.ExcludeName.MatchString(m.Name) {
+		return false, false
+	}
+	if hasTag(m.Tags, f.ExcludeTags) {
+		return false, false
+	}
+	return true, !m.Authorized
+}
+
+// hasTag reports whether one of tags, as "<id>=<value>", is in want. Entries of want are "<id>" to match
+// any value or "<id>=<value>".
+func hasTag(tags, want []string) bool {
+	for _, tag := range tags {
+		id, _, _ := strings.Cut(tag, "=")
+		if slices.Contains(want, tag) || slices.Contains(want, id) {
+			return true
+		}
+	}
+	return false
+}
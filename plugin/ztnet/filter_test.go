@@ -0,0 +1,80 @@
+package ztnet
+
+import (
+	"net"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestMemberFilter(t *testing.T) {
+	now := time.Now()
+	f := &MemberFilter{
+		OnlineWithin: time.Hour,
+		ExcludeTags:  []string{"100=1", "200"},
+		ExcludeName:  regexp.MustCompile(`(?i)^test-`),
+	}
+	tests := []struct {
+		name    string
+		member  Member
+		serve   bool
+		pending bool
+	}{
+		{"online", Member{Name: "laptop", Authorized: true, LastSeen: now.Add(-time.Minute)}, true, false},
+		{"unknown last seen", Member{Name: "laptop", Authorized: true}, true, false},
+		{"offline", Member{Name: "laptop", Authorized: true, LastSeen: now.Add(-48 * time.Hour)}, false, false},
+		{"unauthorized", Member{Name: "laptop"}, false, false},
+		{"tag value", Member{Name: "laptop", Authorized: true, Tags: []string{"100=1"}}, false, false},
+		{"other tag value", Member{Name: "laptop", Authorized: true, Tags: []string{"100=2"}}, true, false},
+		{"tag id", Member{Name: "laptop", Authorized: true, Tags: []string{"200=7"}}, false, false},
+		{"name", Member{Name: "Test-VM", Authorized: true}, false, false},
+	}
+	for _, tc := range tests {
+		serve, pending := f.match(tc.member, now)
+		if serve != tc.serve || pending != tc.pending {
+			t.Errorf("%s: want %v %v, got %v %v", tc.name, tc.serve, tc.pending, serve, pending)
+		}
+	}
+
+	f.Pending = "pending"
+	if serve, pending := f.match(Member{Name: "laptop"}, now); !serve || !pending {
+		t.Errorf("expected unauthorized member to be pending, got %v %v", serve, pending)
+	}
+}
+
+func TestBuildPendingMembers(t *testing.T) {
+	rc := &RecordCache{networks: map[string]*networkState{
+		"8056c2e21c000001": {info: &NetworkInfo{}, members: []Member{
+			{ID: "aaaaaaaaaa", Name: "server", Authorized: true, IPs: []net.IP{net.ParseIP("10.0.0.1")}},
+			{ID: "bbbbbbbbbb", Name: "server", IPs: []net.IP{net.ParseIP("10.0.0.2")}, Description: "dns: alias=www"},
+		}},
+	}}
+	networks := []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}}
+	cfg := &Config{Networks: networks, Filter: MemberFilter{Pending: "pending"}}
+	records := rc.build(cfg, networks, time.Now())
+
+	if ips := records.Hosts["server.home.lan."]; len(ips) != 1 || ips[0].String() != "10.0.0.1" {
+		t.Fatalf("unexpected addresses for server.home.lan.: %v", ips)
+	}
+	if ips := records.Hosts["server.pending.home.lan."]; len(ips) != 1 || ips[0].String() != "10.0.0.2" {
+		t.Fatalf("unexpected addresses for server.pending.home.lan.: %v", ips)
+	}
+	if _, ok := records.Hosts["bbbbbbbbbb.pending.home.lan."]; !ok {
+		t.Fatal("expected ID name of pending member")
+	}
+	if _, ok := records.PTR["2.0.0.10.in-addr.arpa."]; ok {
+		t.Fatal("did not expect PTR record for pending member")
+	}
+	if len(records.CNAME) != 0 {
+		t.Fatalf("did not expect custom records of pending member, got %v", records.CNAME)
+	}
+	if rc.members["home.lan."] != 2 || len(rc.collisions["home.lan."]) != 0 {
+		t.Fatalf("unexpected members %v and collisions %v", rc.members, rc.collisions)
+	}
+
+	cfg.Filter.Pending = ""
+	records = rc.build(cfg, networks, time.Now())
+	if _, ok := records.Hosts["server.pending.home.lan."]; ok || rc.members["home.lan."] != 1 {
+		t.Fatal("did not expect unauthorized member without pending")
+	}
+}
@@ -0,0 +1,60 @@
+package ztnet
+
+import (
+	"bytes"
+	"net"
+	"slices"
+)
+
+// IPRange is an inclusive range of addresses, like an address assignment pool.
+type IPRange struct {
+	Start net.IP
+	End   net.IP
+}
+
+// contains reports whether ip is in r.
+func (r IPRange) contains(ip net.IP) bool {
+	ip16 := ip.To16()
+	return ip16 != nil && bytes.Compare(r.Start.To16(), ip16) <= 0 && bytes.Compare(ip16, r.End.To16()) <= 0
+}
+
+// prefixRange returns the range of addresses in n.
+func prefixRange(n *net.IPNet) IPRange {
+	start := n.IP.Mask(n.Mask)
+	end := make(net.IP, len(start))
+	for i := range start {
+		end[i] = start[i] | ^n.Mask[i]
+	}
+	return IPRange{Start: start, End: end}
+}
+
+// clientRanges returns the addresses of the clients that belong to a network: its managed routes,
+// address pools, RFC4193 and 6plane prefixes and the addresses of its members.
+func clientRanges(networkID string, info *NetworkInfo, addrs []net.IP) []IPRange {
+	var ranges []IPRange
+	for _, n := range networkPrefixes(networkID, info) {
+		ranges = append(ranges, prefixRange(n))
+	}
+	ranges = append(ranges, info.Pools...)
+	for _, ip := range addrs {
+		if !slices.ContainsFunc(ranges, func(r IPRange) bool { return r.contains(ip) }) {
+			ranges = append(ranges, IPRange{Start: ip, End: ip})
+		}
+	}
+	return ranges
+}
+
+// allowed reports whether the client with address ip may query zone. Zones of networks that only
+// answer their members refuse all clients until the network is loaded.
+func (z *ZTNet) allowed(zone string, ip net.IP) bool {
+	ranges, restricted := z.Cache.ClientRanges(zone)
+	if !restricted {
+		restricted = slices.ContainsFunc(z.Config.Networks, func(nz NetworkZone) bool {
+			return nz.Zone == zone && z.Config.membersOnly(nz)
+		})
+	}
+	if !restricted {
+		return true
+	}
+	return ip != nil && slices.ContainsFunc(ranges, func(r IPRange) bool { return r.contains(ip) })
+}
@@ -0,0 +1,153 @@
+package ztnet
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
+	"github.com/coredns/coredns/plugin/pkg/fall"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestClientRanges(t *testing.T) {
+	_, route, _ := net.ParseCIDR("10.147.17.0/24")
+	info := &NetworkInfo{
+		RFC4193: true,
+		Routes:  []*net.IPNet{route},
+		Pools:   []IPRange{{Start: net.ParseIP("192.168.196.10"), End: net.ParseIP("192.168.196.20")}},
+	}
+	ranges := clientRanges("8056c2e21c000001", info, []net.IP{net.ParseIP("10.147.17.2"), net.ParseIP("172.16.0.5")})
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.147.17.0", true},
+		{"10.147.17.255", true},
+		{"10.147.18.1", false},
+		{"192.168.196.15", true},
+		{"192.168.196.21", false},
+		{"172.16.0.5", true},
+		{"172.16.0.6", false},
+		{"fd80:56c2:e21c:0:199:93ab:cdef:1234", true},
+		{"fd80:56c2:e21c:1::1", false},
+	}
+	for _, tc := range tests {
+		got := false
+		for _, r := range ranges {
+			got = got || r.contains(net.ParseIP(tc.ip))
+		}
+		if got != tc.want {
+			t.Errorf("%s: want %v, got %v", tc.ip, tc.want, got)
+		}
+	}
+}
+
+func newMembersOnlyPlugin() *ZTNet {
+	_, route, _ := net.ParseCIDR("10.147.17.0/24")
+	rc := &RecordCache{networks: map[string]*networkState{
+		"8056c2e21c000001": {info: &NetworkInfo{Routes: []*net.IPNet{route}}, members: []Member{
+			{Authorized: true, ID: "efcc1b0947", Name: "node", IPs: []net.IP{net.ParseIP("10.147.17.2")}},
+		}},
+		"abcdef01234567aa": {info: &NetworkInfo{}, members: []Member{
+			{Authorized: true, ID: "aaaaaaaaaa", Name: "web", IPs: []net.IP{net.ParseIP("192.0.2.10")}},
+		}},
+	}}
+	networks := []NetworkZone{
+		{Zone: "home.lan.", NetworkID: "8056c2e21c000001", MembersOnly: true},
+		{Zone: "public.lan.", NetworkID: "abcdef01234567aa"},
+	}
+	cfg := &Config{Networks: networks, DNSTTL: 30 * time.Second}
+	rc.Replace(rc.build(cfg, networks, time.Now()))
+	return &ZTNet{Config: cfg, Cache: rc}
+}
+
+func TestServeDNSMembersOnly(t *testing.T) {
+	z := newMembersOnlyPlugin()
+	tests := []struct {
+		qname    string
+		remoteIP string
+		rcode    int
+	}{
+		{"node.home.lan.", "10.147.17.99", dns.RcodeSuccess},
+		{"node.home.lan.", "192.0.2.1", dns.RcodeRefused},
+		{"2.17.147.10.in-addr.arpa.", "192.0.2.1", dns.RcodeRefused},
+		{"2.17.147.10.in-addr.arpa.", "10.147.17.2", dns.RcodeSuccess},
+		{"web.public.lan.", "192.0.2.1", dns.RcodeSuccess},
+	}
+	for _, tc := range tests {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{RemoteIP: tc.remoteIP})
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, dns.TypeA)
+		if dnsutil.IsReverse(tc.qname) > 0 {
+			m.SetQuestion(tc.qname, dns.TypePTR)
+		}
+		rcode, err := z.ServeDNS(context.Background(), rec, m)
+		if err != nil {
+			t.Fatalf("%s from %s: ServeDNS error: %v", tc.qname, tc.remoteIP, err)
+		}
+		if tc.rcode == dns.RcodeRefused && rcode != dns.RcodeRefused {
+			t.Errorf("%s from %s: expected REFUSED, got %d", tc.qname, tc.remoteIP, rcode)
+		}
+		if tc.rcode == dns.RcodeSuccess && (rec.Msg == nil || len(rec.Msg.Answer) != 1) {
+			t.Errorf("%s from %s: expected an answer, got rcode %d", tc.qname, tc.remoteIP, rcode)
+		}
+	}
+}
+
+func TestServeDNSMembersOnlyFallthrough(t *testing.T) {
+	z := newMembersOnlyPlugin()
+	z.Fall = fall.Root
+	z.Next = test.NextHandler(dns.RcodeNameError, nil)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{RemoteIP: "192.0.2.1"})
+	m := new(dns.Msg)
+	m.SetQuestion("node.home.lan.", dns.TypeA)
+	if rcode, _ := z.ServeDNS(context.Background(), rec, m); rcode != dns.RcodeNameError {
+		t.Fatalf("expected the next plugin to answer, got rcode %d", rcode)
+	}
+}
+
+func TestServeDNSMembersOnlySharedZone(t *testing.T) {
+	_, route, _ := net.ParseCIDR("10.147.17.0/24")
+	rc := &RecordCache{networks: map[string]*networkState{
+		"8056c2e21c000001": {info: &NetworkInfo{Routes: []*net.IPNet{route}}, members: []Member{
+			{Authorized: true, ID: "efcc1b0947", Name: "node", IPs: []net.IP{net.ParseIP("10.147.17.2")}},
+		}},
+		"abcdef01234567aa": {info: &NetworkInfo{}, members: []Member{
+			{Authorized: true, ID: "aaaaaaaaaa", Name: "web", IPs: []net.IP{net.ParseIP("192.0.2.10")}},
+		}},
+	}}
+	networks := []NetworkZone{
+		{Zone: "home.lan.", NetworkID: "8056c2e21c000001", MembersOnly: true},
+		{Zone: "home.lan.", NetworkID: "abcdef01234567aa"},
+	}
+	cfg := &Config{Networks: networks, DNSTTL: 30 * time.Second}
+	rc.Replace(rc.build(cfg, networks, time.Now()))
+	z := &ZTNet{Config: cfg, Cache: rc}
+
+	for ip, want := range map[string]bool{"10.147.17.99": true, "192.0.2.10": true, "192.0.2.1": false} {
+		if got := z.allowed("home.lan.", net.ParseIP(ip)); got != want {
+			t.Errorf("%s: want allowed %v, got %v", ip, want, got)
+		}
+	}
+}
+
+func TestAllowedBeforeLoad(t *testing.T) {
+	z := &ZTNet{
+		Config: &Config{Networks: []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}}, MembersOnly: true},
+		Cache:  &RecordCache{},
+	}
+	if z.allowed("home.lan.", net.ParseIP("10.147.17.2")) {
+		t.Fatal("expected members only zone to refuse clients before the network is loaded")
+	}
+	z.Config.MembersOnly = false
+	if !z.allowed("home.lan.", net.ParseIP("10.147.17.2")) {
+		t.Fatal("expected zone to answer all clients")
+	}
+}
@@ -0,0 +1,52 @@
+package ztnet
+
+import (
+	"net"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+)
+
+// boundIPs returns the list of non-loopback IPs that CoreDNS is bound to.
+func boundIPs(c *caddy.Controller) (ips []net.IP) {
+	conf := dnsserver.GetConfig(c)
+	hosts := conf.ListenHosts
+	if len(hosts) == 0 || hosts[0] == "" {
+		hosts = nil
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return nil
+		}
+		for _, addr := range addrs {
+			hosts = append(hosts, addr.String())
+		}
+	}
+	for _, host := range hosts {
+		ip, _, _ := net.ParseCIDR(host)
+		if ip == nil {
+			ip = net.ParseIP(host)
+		}
+		if ip4 := ip.To4(); ip4 != nil && !ip4.IsLoopback() {
+			ips = append(ips, ip4)
+			continue
+		}
+		if ip6 := ip.To16(); ip6 != nil && !ip6.IsLoopback() {
+			ips = append(ips, ip6)
+		}
+	}
+	return ips
+}
+
+// serverAddrs returns the addresses of the servers CoreDNS runs for the server block, as they appear in
+// the server label of metrics.
+func serverAddrs(c *caddy.Controller) (addrs []string) {
+	conf := dnsserver.GetConfig(c)
+	for _, host := range conf.ListenHosts {
+		addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(host, conf.Port))
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, conf.Transport+"://"+addr.String())
+	}
+	return addrs
+}
@@ -0,0 +1,47 @@
+package ztnet
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/request"
+)
+
+// Metadata implements the metadata.Provider interface. When the client address belongs to a served
+// member, the ZeroTier identity of the member is exposed as metadata.
+func (z *ZTNet) Metadata(ctx context.Context, state request.Request) context.Context {
+	ip := net.ParseIP(state.IP())
+	if ip == nil {
+		return ctx
+	}
+	id, ok := z.Cache.LookupIdentity(ip)
+	if !ok {
+		return ctx
+	}
+
+	metadata.SetValueFunc(ctx, "ztnet/member_id", func() string {
+		return id.MemberID
+	})
+
+	metadata.SetValueFunc(ctx, "ztnet/member_name", func() string {
+		return id.MemberName
+	})
+
+	metadata.SetValueFunc(ctx, "ztnet/network_id", func() string {
+		return id.NetworkID
+	})
+
+	metadata.SetValueFunc(ctx, "ztnet/tags", func() string {
+		return strings.Join(id.Tags, ",")
+	})
+
+	for _, tag := range id.Tags {
+		tagID, value, _ := strings.Cut(tag, "=")
+		metadata.SetValueFunc(ctx, "ztnet/tag/"+tagID, func() string {
+			return value
+		})
+	}
+	return ctx
+}
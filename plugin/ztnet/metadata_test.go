@@ -0,0 +1,62 @@
+package ztnet
+
+import (
+	"context"
+	"maps"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+func TestMetadata(t *testing.T) {
+	rc := &RecordCache{networks: map[string]*networkState{
+		"8056c2e21c000001": {info: &NetworkInfo{RFC4193: true}, members: []Member{
+			{Authorized: true, ID: "efcc1b0947", Name: "Alice Laptop", IPs: []net.IP{net.ParseIP("10.0.0.2")}, Tags: []string{"100=1", "200=7"}},
+			{ID: "deadbeef00", Name: "new", IPs: []net.IP{net.ParseIP("10.0.0.3")}},
+		}},
+	}}
+	networks := []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}}
+	cfg := &Config{Networks: networks, Filter: MemberFilter{Pending: "pending"}}
+	rc.Replace(rc.build(cfg, networks, time.Now()))
+	z := &ZTNet{Config: cfg, Cache: rc}
+
+	alice := map[string]string{
+		"ztnet/member_id":   "efcc1b0947",
+		"ztnet/member_name": "Alice Laptop",
+		"ztnet/network_id":  "8056c2e21c000001",
+		"ztnet/tags":        "100=1,200=7",
+		"ztnet/tag/100":     "1",
+		"ztnet/tag/200":     "7",
+	}
+	tests := []struct {
+		remoteIP string
+		want     map[string]string
+	}{
+		{"10.0.0.2", alice},
+		{"fd80:56c2:e21c:0:199:93ef:cc1b:947", alice},
+		{"10.0.0.3", map[string]string{}}, // pending members are not identified
+		{"192.0.2.1", map[string]string{}},
+	}
+	for _, tc := range tests {
+		ctx := metadata.ContextWithMetadata(context.Background())
+		state := request.Request{
+			Req: &dns.Msg{Question: []dns.Question{{Name: "example.org.", Qtype: dns.TypeA}}},
+			W:   &test.ResponseWriter{RemoteIP: tc.remoteIP},
+		}
+		z.Metadata(ctx, state)
+
+		md := make(map[string]string)
+		for _, l := range metadata.Labels(ctx) {
+			md[l] = metadata.ValueFunc(ctx, l)()
+		}
+		if !maps.Equal(md, tc.want) {
+			t.Errorf("%s: want metadata %v, got %v", tc.remoteIP, tc.want, md)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package ztnet
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// apiRequestDuration is the duration of ZTNET API requests by endpoint, network and status.
+	apiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ztnet",
+		Name:      "api_request_duration_seconds",
+		Buckets:   plugin.TimeBuckets,
+		Help:      "Histogram of the time each API request took.",
+	}, []string{"endpoint", "network", "status"})
+	// refreshSuccessCount is the number of successful network refreshes.
+	refreshSuccessCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ztnet",
+		Name:      "refresh_success_total",
+		Help:      "Counter of successful network refreshes.",
+	}, []string{"network"})
+	// refreshFailureCount is the number of failed network refreshes.
+	refreshFailureCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ztnet",
+		Name:      "refresh_failures_total",
+		Help:      "Counter of failed network refreshes.",
+	}, []string{"network"})
+	// lastSuccessTime is the timestamp of the last successful refresh of a network.
+	lastSuccessTime = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ztnet",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "The timestamp of the last successful refresh of a network.",
+	}, []string{"network"})
+	// networkStale is 1 when a network is served from stale data.
+	networkStale = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ztnet",
+		Name:      "network_stale",
+		Help:      "Whether a network is served from stale data (1) or not (0).",
+	}, []string{"network"})
+	// zoneMembers is the number of members served in a zone.
+	zoneMembers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ztnet",
+		Name:      "members",
+		Help:      "The number of members served in a zone.",
+	}, []string{"server", "zone"})
+	// zoneRecords is the number of records served in a zone.
+	zoneRecords = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ztnet",
+		Name:      "records",
+		Help:      "The number of records served in a zone.",
+	}, []string{"server", "zone"})
+	// zoneSerial is the SOA serial of a zone.
+	zoneSerial = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ztnet",
+		Name:      "zone_serial",
+		Help:      "The SOA serial of a zone.",
+	}, []string{"server", "zone"})
+	// zoneLastChange is the timestamp of the last content change of a zone.
+	zoneLastChange = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ztnet",
+		Name:      "zone_last_change_timestamp_seconds",
+		Help:      "The timestamp of the last content change of a zone.",
+	}, []string{"server", "zone"})
+	// nameCollisions is the number of members whose name collided with another member in a zone.
+	nameCollisions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ztnet",
+		Name:      "name_collisions",
+		Help:      "The number of members in a zone whose name maps to the same label as another member.",
+	}, []string{"server", "zone"})
+	// queryCount is the number of queries handled by outcome.
+	queryCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ztnet",
+		Name:      "queries_total",
+		Help:      "Counter of queries by outcome (hit, nodata, nxdomain, servfail, fallthrough, refused).",
+	}, []string{"server", "outcome"})
+)
+
+// Query outcomes used as the outcome label of queryCount.
+const (
+	outcomeHit         = "hit"
+	outcomeNoData      = "nodata"
+	outcomeNXDomain    = "nxdomain"
+	outcomeServfail    = "servfail"
+	outcomeFallthrough = "fallthrough"
+	outcomeRefused     = "refused"
+)
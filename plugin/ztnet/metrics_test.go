@@ -0,0 +1,91 @@
+package ztnet
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRefreshMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := `{"v6AssignMode":{"6plane":false,"rfc4193":true}}`
+		if strings.HasSuffix(r.URL.Path, "/member/") {
+			body = `[{"id":"efcc1b0947","name":"node","authorized":true,"ipAssignments":["10.0.0.2"]},
+				{"id":"efcc1b0948","name":"other","authorized":true,"ipAssignments":[]}]`
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatalf("write response: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := &Config{Networks: []NetworkZone{{Zone: "metrics.lan.", NetworkID: "1111111111111111"}}}
+	before := testutil.ToFloat64(refreshSuccessCount.WithLabelValues("1111111111111111"))
+	rc := &RecordCache{servers: []string{"dns://:53"}}
+	if err := rc.refresh(context.Background(), NewClient(ts.URL, "token"), cfg); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+
+	if got := testutil.ToFloat64(refreshSuccessCount.WithLabelValues("1111111111111111")) - before; got != 1 {
+		t.Fatalf("want 1 successful refresh, got %v", got)
+	}
+	if got := testutil.ToFloat64(zoneMembers.WithLabelValues("dns://:53", "metrics.lan.")); got != 2 {
+		t.Fatalf("want 2 members, got %v", got)
+	}
+	// Two names per member, each with the RFC4193 address and node with its IPv4 address too.
+	if got := testutil.ToFloat64(zoneRecords.WithLabelValues("dns://:53", "metrics.lan.")); got != 6 {
+		t.Fatalf("want 6 records, got %v", got)
+	}
+	if got := testutil.ToFloat64(lastSuccessTime.WithLabelValues("1111111111111111")); got == 0 {
+		t.Fatal("expected last success timestamp")
+	}
+	if got := testutil.CollectAndCount(apiRequestDuration); got == 0 {
+		t.Fatal("expected API request duration samples")
+	}
+
+	// Another server block serving the zone has its own series, dropping the zone there leaves these.
+	other := &RecordCache{servers: []string{"dns://:1053"}}
+	if err := other.refresh(context.Background(), NewClient(ts.URL, "token"), cfg); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+	moved := &Config{Networks: []NetworkZone{{Zone: "moved.lan.", NetworkID: "1111111111111111"}}}
+	if err := other.refresh(context.Background(), NewClient(ts.URL, "token"), moved); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+	if got := testutil.ToFloat64(zoneMembers.WithLabelValues("dns://:53", "metrics.lan.")); got != 2 {
+		t.Fatalf("want 2 members for the first server, got %v", got)
+	}
+	if zoneMembers.DeleteLabelValues("dns://:1053", "metrics.lan.") {
+		t.Fatal("expected the members of metrics.lan. on the other server to be deleted")
+	}
+}
+
+func TestQueryMetrics(t *testing.T) {
+	z := newPlugin()
+	for _, tc := range []struct {
+		qname   string
+		outcome string
+	}{
+		{"node.home.lan.", outcomeHit},
+		{"unknown.home.lan.", outcomeNXDomain},
+		{"example.org.", outcomeRefused},
+	} {
+		before := testutil.ToFloat64(queryCount.WithLabelValues("", tc.outcome))
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, dns.TypeA)
+		if _, err := z.ServeDNS(context.Background(), dnstest.NewRecorder(&test.ResponseWriter{}), m); err != nil {
+			t.Fatalf("ServeDNS error: %v", err)
+		}
+		if got := testutil.ToFloat64(queryCount.WithLabelValues("", tc.outcome)) - before; got != 1 {
+			t.Fatalf("%s: want 1 %s query, got %v", tc.qname, tc.outcome, got)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package ztnet
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
+
+	"golang.org/x/net/idna"
+)
+
+// maxLabelLength is the maximum length of a DNS label, RFC 1035 section 2.3.4.
+const maxLabelLength = 63
+
+// Policies for members of a zone whose names map to the same label.
+const (
+	// DuplicateMerge serves the addresses of all members under the shared name.
+	DuplicateMerge = "merge"
+	// DuplicateFirst serves the shared name for the member with the lowest ID only.
+	DuplicateFirst = "first"
+	// DuplicateSuffix serves the shared name for the member with the lowest ID, the other members get
+	// their ID appended as <name>-<memberID>.
+	DuplicateSuffix = "suffix"
+	// DuplicateSkip serves the shared name for none of the members.
+	DuplicateSkip = "skip"
+)
+
+// defaultNames are the names of a member when no names are configured: its name and its ID.
+var defaultNames = []*template.Template{
+	template.Must(template.New("name").Parse("{{.Name}}")),
+	template.Must(template.New("name").Parse("{{.ID}}")),
+}
+
+// nameRegex matches a relative domain name of one or more host labels.
+var nameRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9\-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9\-]{0,61}[a-z0-9])?)*$`)
+
+// nameData is the data the names templates are executed with. The fields are derived from the member
+// and network IDs and the member name only, so every backend provides all of them.
+type nameData struct {
+	// Name is the member name as a DNS label, after the duplicates policy. It is empty for members
+	// without a usable name.
+	Name string
+	// ID is the member ID.
+	ID string
+	// NetworkID is the network ID.
+	NetworkID string
+	// NetworkShortID is the network number, the last 6 digits of the network ID.
+	NetworkShortID string
+}
+
+// parseNameTemplates parses names templates and checks they yield valid names.
+func parseNameTemplates(texts []string) ([]*template.Template, error) {
+	var tmpls []*template.Template
+	for _, text := range texts {
+		tmpl, err := template.New("name").Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := memberName(tmpl, nameData{Name: "example", ID: "efcc1b0947", NetworkID: "8056c2e21c000001", NetworkShortID: "000001"}); err != nil {
+			return nil, err
+		}
+		tmpls = append(tmpls, tmpl)
+	}
+	return tmpls, nil
+}
+
+// memberName executes a names template and returns the relative name it yields.
+func memberName(tmpl *template.Template, data nameData) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	name := strings.TrimSuffix(strings.ToLower(sb.String()), ".")
+	if !nameRegex.MatchString(name) {
+		return "", fmt.Errorf("invalid name %q", name)
+	}
+	return name, nil
+}
+
+// memberNames returns the names of a member in zone, in template order and without duplicates. A
+// template that does not yield a valid name for the member, like {{.Name}} for a member without a
+// name, is skipped.
+func memberNames(tmpls []*template.Template, zone, networkID, memberID, label string) []string {
+	data := nameData{Name: label, ID: strings.ToLower(memberID), NetworkID: networkID}
+	if len(networkID) > 6 {
+		data.NetworkShortID = networkID[len(networkID)-6:]
+	}
+	var names []string
+	for _, tmpl := range tmpls {
+		name, err := memberName(tmpl, data)
+		if err != nil {
+			continue
+		}
+		if name = dnsutil.Join(name, zone); !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// sanitizeLabel turns name into a single RFC 1123 host label: lowercase letters, digits and hyphens,
+// not starting or ending with a hyphen and at most 63 characters long. Every run of other characters
+// becomes a single hyphen. With useIDNA, unicode letters are kept and the label is converted to
+// punycode. The empty string is returned when nothing usable is left.
+func sanitizeLabel(name string, useIDNA bool) string {
+	if useIDNA {
+		if label := sanitize(name, true); label != "" {
+			if ascii, err := idna.Lookup.ToASCII(label); err == nil && len(ascii) <= maxLabelLength {
+				return ascii
+			}
+		}
+	}
+	return sanitize(name, false)
+}
+
+func sanitize(name string, unicodeLetters bool) string {
+	var sb strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		keep := (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9')
+		if !keep && unicodeLetters && r >= utf8.RuneSelf {
+			keep = unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+		}
+		if keep {
+			sb.WriteRune(r)
+			hyphen = false
+			continue
+		}
+		if !hyphen && sb.Len() > 0 {
+			sb.WriteByte('-')
+			hyphen = true
+		}
+	}
+	label := strings.TrimSuffix(sb.String(), "-")
+	if !unicodeLetters && len(label) > maxLabelLength {
+		label = strings.TrimSuffix(label[:maxLabelLength], "-")
+	}
+	return label
+}
+
+// memberLabels returns the label of every member of a zone by member ID, members without a usable
+// name are left out. Members whose names map to the same label are handled according to policy, the
+// colliding labels are returned with the IDs of the members that share them.
+func memberLabels(members []Member, useIDNA bool, policy string) (map[string]string, map[string][]string) {
+	owners := make(map[string][]string)
+	for _, m := range members {
+		label := sanitizeLabel(m.Name, useIDNA)
+		if label == "" {
+			continue
+		}
+		if !slices.Contains(owners[label], m.ID) {
+			owners[label] = append(owners[label], m.ID)
+		}
+	}
+
+	labels := make(map[string]string)
+	collisions := make(map[string][]string)
+	for label, ids := range owners {
+		if len(ids) == 1 {
+			labels[ids[0]] = label
+			continue
+		}
+		slices.Sort(ids)
+		collisions[label] = ids
+		switch policy {
+		case DuplicateFirst:
+			labels[ids[0]] = label
+		case DuplicateSuffix:
+			labels[ids[0]] = label
+			for _, id := range ids[1:] {
+				labels[id] = suffixLabel(label, id)
+			}
+		case DuplicateSkip:
+		default:
+			for _, id := range ids {
+				labels[id] = label
+			}
+		}
+	}
+	return labels, collisions
+}
+
+// suffixLabel returns label-id, shortening label to keep the result a valid label.
+func suffixLabel(label, id string) string {
+	if n := maxLabelLength - len(id) - 1; len(label) > n {
+		label = strings.TrimSuffix(label[:n], "-")
+	}
+	return label + "-" + id
+}
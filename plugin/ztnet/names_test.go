@@ -0,0 +1,146 @@
+package ztnet
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSanitizeLabel(t *testing.T) {
+	tests := []struct {
+		name string
+		idna bool
+		want string
+	}{
+		{"node one", false, "node-one"},
+		{"Web.Server_01", false, "web-server-01"},
+		{"  --dev--  ", false, "dev"},
+		{"Büro PC", false, "b-ro-pc"},
+		{"Büro PC", true, "xn--bro-pc-3ya"},
+		{"日本", true, "xn--wgv71a"},
+		{"日本", false, ""},
+		{"!!!", true, ""},
+		{strings.Repeat("a", 70), false, strings.Repeat("a", 63)},
+		{strings.Repeat("a", 62) + " b", false, strings.Repeat("a", 62)},
+	}
+	for _, tc := range tests {
+		if got := sanitizeLabel(tc.name, tc.idna); got != tc.want {
+			t.Errorf("sanitizeLabel(%q, %v): want %q, got %q", tc.name, tc.idna, tc.want, got)
+		}
+	}
+}
+
+func TestMemberLabels(t *testing.T) {
+	members := []Member{
+		{ID: "bbbbbbbbbb", Name: "Node"},
+		{ID: "aaaaaaaaaa", Name: "node"},
+		{ID: "cccccccccc", Name: "other"},
+		{ID: "dddddddddd", Name: ""},
+	}
+	tests := []struct {
+		policy string
+		want   map[string]string
+	}{
+		{DuplicateMerge, map[string]string{"aaaaaaaaaa": "node", "bbbbbbbbbb": "node", "cccccccccc": "other"}},
+		{DuplicateFirst, map[string]string{"aaaaaaaaaa": "node", "cccccccccc": "other"}},
+		{DuplicateSuffix, map[string]string{"aaaaaaaaaa": "node", "bbbbbbbbbb": "node-bbbbbbbbbb", "cccccccccc": "other"}},
+		{DuplicateSkip, map[string]string{"cccccccccc": "other"}},
+	}
+	for _, tc := range tests {
+		labels, collisions := memberLabels(members, false, tc.policy)
+		if len(labels) != len(tc.want) {
+			t.Fatalf("%s: want %v, got %v", tc.policy, tc.want, labels)
+		}
+		for id, label := range tc.want {
+			if labels[id] != label {
+				t.Fatalf("%s: want %v, got %v", tc.policy, tc.want, labels)
+			}
+		}
+		if ids := collisions["node"]; len(collisions) != 1 || len(ids) != 2 || ids[0] != "aaaaaaaaaa" {
+			t.Fatalf("%s: unexpected collisions %v", tc.policy, collisions)
+		}
+	}
+}
+
+func TestSuffixLabel(t *testing.T) {
+	if got := suffixLabel(strings.Repeat("a", 60), "efcc1b0947"); len(got) != maxLabelLength || !strings.HasSuffix(got, "-efcc1b0947") {
+		t.Fatalf("unexpected label %q", got)
+	}
+}
+
+func TestBuildNameCollisions(t *testing.T) {
+	rc := &RecordCache{networks: map[string]*networkState{
+		"8056c2e21c000001": {info: &NetworkInfo{}, members: []Member{
+			{Authorized: true, ID: "aaaaaaaaaa", Name: "web", IPs: []net.IP{net.ParseIP("10.0.0.1")}},
+		}},
+		"abcdef01234567aa": {info: &NetworkInfo{}, members: []Member{
+			{Authorized: true, ID: "bbbbbbbbbb", Name: "Web", IPs: []net.IP{net.ParseIP("10.0.1.1")}},
+		}},
+	}}
+	networks := []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}, {Zone: "home.lan.", NetworkID: "abcdef01234567aa"}}
+	cfg := &Config{Networks: networks, Duplicates: DuplicateSuffix}
+
+	records := rc.build(cfg, networks, time.Now())
+	if ips := records.Hosts["web.home.lan."]; len(ips) != 1 || ips[0].String() != "10.0.0.1" {
+		t.Fatalf("unexpected addresses for web.home.lan.: %v", ips)
+	}
+	if ips := records.Hosts["web-bbbbbbbbbb.home.lan."]; len(ips) != 1 || ips[0].String() != "10.0.1.1" {
+		t.Fatalf("unexpected addresses for web-bbbbbbbbbb.home.lan.: %v", ips)
+	}
+	if targets := records.PTR["1.1.0.10.in-addr.arpa."]; len(targets) != 1 || targets[0] != "web-bbbbbbbbbb.home.lan." {
+		t.Fatalf("unexpected PTR targets %v", targets)
+	}
+	if ids := rc.collisions["home.lan."]["web"]; len(ids) != 2 {
+		t.Fatalf("expected collision to be recorded, got %v", rc.collisions)
+	}
+}
+
+func TestMemberNames(t *testing.T) {
+	tmpls, err := parseNameTemplates([]string{"{{.Name}}", "{{.ID}}.id", "{{.Name}}-{{.NetworkShortID}}", "{{.Name}}"})
+	if err != nil {
+		t.Fatalf("parseNameTemplates error: %v", err)
+	}
+	names := memberNames(tmpls, "home.lan.", "8056c2e21c000001", "EFCC1B0947", "laptop")
+	want := []string{"laptop.home.lan.", "efcc1b0947.id.home.lan.", "laptop-000001.home.lan."}
+	if !slices.Equal(names, want) {
+		t.Fatalf("want %v, got %v", want, names)
+	}
+
+	// A member without a name only gets the names that do not use it.
+	names = memberNames(tmpls, "home.lan.", "8056c2e21c000001", "efcc1b0947", "")
+	if want := []string{"efcc1b0947.id.home.lan."}; !slices.Equal(names, want) {
+		t.Fatalf("want %v, got %v", want, names)
+	}
+}
+
+func TestBuildNameTemplates(t *testing.T) {
+	rc := &RecordCache{networks: map[string]*networkState{
+		"8056c2e21c000001": {info: &NetworkInfo{}, members: []Member{
+			{Authorized: true, ID: "aaaaaaaaaa", Name: "web", IPs: []net.IP{net.ParseIP("10.0.0.1")}},
+		}},
+		"abcdef01234567aa": {info: &NetworkInfo{}, members: []Member{
+			{Authorized: true, ID: "bbbbbbbbbb", Name: "db", IPs: []net.IP{net.ParseIP("10.0.1.1")}},
+		}},
+	}}
+	shared, err := parseNameTemplates([]string{"{{.Name}}-{{.NetworkShortID}}"})
+	if err != nil {
+		t.Fatalf("parseNameTemplates error: %v", err)
+	}
+	networks := []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}, {Zone: "shared.lan.", NetworkID: "abcdef01234567aa", Names: shared}}
+	cfg := &Config{Networks: networks}
+
+	records := rc.build(cfg, networks, time.Now())
+	for _, name := range []string{"web.home.lan.", "aaaaaaaaaa.home.lan.", "db-4567aa.shared.lan."} {
+		if _, ok := records.Hosts[name]; !ok {
+			t.Errorf("expected name %s, got %v", name, records.Hosts)
+		}
+	}
+	if _, ok := records.Hosts["bbbbbbbbbb.shared.lan."]; ok {
+		t.Error("did not expect the member ID name in shared.lan.")
+	}
+	if targets := records.PTR["1.1.0.10.in-addr.arpa."]; len(targets) != 1 || targets[0] != "db-4567aa.shared.lan." {
+		t.Fatalf("unexpected PTR targets %v", targets)
+	}
+}
@@ -0,0 +1,24 @@
+package ztnet
+
+import "time"
+
+// Ready implements the ready.Readiness interface. The plugin is ready once every network has been
+// fetched from the API successfully, data loaded from a snapshot file is served but does not count. When
+// unhealthy_after is set, it stops being ready as soon as the oldest successful sync is older than that.
+func (z *ZTNet) Ready() bool {
+	last := z.Cache.LastSync()
+	if last.IsZero() {
+		return false
+	}
+	return z.Config.UnhealthyAfter == 0 || z.SyncAge() <= z.Config.UnhealthyAfter
+}
+
+// SyncAge returns the age of the oldest successful sync across all networks, or zero when the
+// networks have not all been loaded yet.
+func (z *ZTNet) SyncAge() time.Duration {
+	last := z.Cache.LastSync()
+	if last.IsZero() {
+		return 0
+	}
+	return time.Since(last)
+}
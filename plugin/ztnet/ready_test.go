@@ -0,0 +1,58 @@
+package ztnet
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReady(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "abcdef01234567aa") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body := `{}`
+		if strings.HasSuffix(r.URL.Path, "/member/") {
+			body = `[]`
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatalf("write response: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	z := &ZTNet{
+		Config: &Config{Networks: []NetworkZone{
+			{Zone: "home.lan.", NetworkID: "8056c2e21c000001"},
+			{Zone: "work.lan.", NetworkID: "abcdef01234567aa"},
+		}},
+		Cache:   &RecordCache{},
+		Backend: NewClient(ts.URL, "token"),
+	}
+	if z.Ready() {
+		t.Fatal("did not expect ready before the first sync")
+	}
+
+	_ = z.Cache.refresh(context.Background(), z.Backend, z.Config)
+	if z.Ready() {
+		t.Fatal("did not expect ready while a network was never loaded")
+	}
+
+	z.Config.Networks = z.Config.Networks[:1]
+	if err := z.Cache.refresh(context.Background(), z.Backend, z.Config); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+	if !z.Ready() {
+		t.Fatal("expected ready after the first successful sync")
+	}
+
+	z.Config.UnhealthyAfter = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if z.SyncAge() < time.Millisecond || z.Ready() {
+		t.Fatalf("expected not ready with sync age %s", z.SyncAge())
+	}
+}
@@ -0,0 +1,208 @@
+package ztnet
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pollers holds the running pollers of the process, it outlives Corefile reloads.
+var pollers = &registry{running: make(map[*poller]struct{})}
+
+// registry tracks the running pollers. Pollers hand their network data over to the pollers of the next
+// Corefile instance, so a reload starts with a warm cache.
+type registry struct {
+	mu      sync.Mutex
+	running map[*poller]struct{}
+}
+
+// poller refreshes the records of the ztnet configuration of one server block. The networks themselves
+// are fetched through the shared sources, so pollers of several server blocks, like the blocks of several
+// views, fetch each network once.
+type poller struct {
+	cfg     *Config
+	cache   *RecordCache
+	backend Backend
+	webhook *webhook
+
+	cancel context.CancelFunc
+}
+
+func newPoller(cfg *Config) *poller {
+	p := &poller{cfg: cfg, cache: &RecordCache{wildcard: cfg.Wildcard, sources: sources}, backend: newBackend(cfg)}
+	if cfg.WebhookAddr != "" {
+		p.webhook = newWebhook(cfg, p.cache.Trigger)
+	}
+	return p
+}
+
+// start starts p. A poller that starts takes over the network data of the running pollers, which belong
+// to the previous Corefile instance on a reload, and falls back to the snapshot for the networks none of
+// them has.
+func (r *registry) start(p *poller) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p.webhook != nil {
+		if err := p.webhook.OnStartup(); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	if p.cfg.Snapshot != "" {
+		if err := p.cache.loadSnapshot(p.cfg, now); err != nil {
+			log.Warningf("Not using snapshot: %v", err)
+		}
+	}
+	for other := range r.running {
+		if s := other.handOver(p.cfg); s != nil {
+			if n := p.cache.restore(p.cfg, s, now); n > 0 {
+				log.Infof("Took over the data of %d networks from the previous configuration", n)
+			}
+		}
+	}
+
+	var ctx context.Context
+	ctx, p.cancel = context.WithCancel(context.Background())
+	go p.cache.refreshLoop(ctx, p.backend, p.cfg)
+	r.running[p] = struct{}{}
+	return nil
+}
+
+// stop stops p.
+func (r *registry) stop(p *poller) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.running[p]; !ok {
+		return
+	}
+	p.cancel()
+	if p.webhook != nil {
+		p.webhook.OnShutdown()
+	}
+	delete(r.running, p)
+}
+
+// sources holds the network sources of the process, they are shared by all record caches that serve a
+// network from the same endpoint with the same credentials, whatever their server block or Corefile
+// instance.
+var sources = &networkSources{sources: make(map[string]*networkSource)}
+
+// networkSources hands out the source of a network, keyed by endpoint and credentials, see
+// Config.endpoint, and network ID.
+type networkSources struct {
+	mu      sync.Mutex
+	sources map[string]*networkSource
+}
+
+// networkSource fetches a single network. Record caches that are due for a refresh of the network at
+// the same time fetch it once, the others get the result of that fetch.
+type networkSource struct {
+	key   string
+	users int // guarded by networkSources.mu
+
+	mu       sync.Mutex // held while fetching
+	last     *fetchResult
+	failures int
+}
+
+// fetchResult is the outcome of fetching a network.
+type fetchResult struct {
+	info    *NetworkInfo
+	members []Member
+	err     error
+	// started is the time the fetch started, failures the number of consecutive failed fetches and
+	// retry the backoff delay after a failure.
+	started  time.Time
+	failures int
+	retry    time.Duration
+}
+
+// acquire returns the source of key, a new one when no record cache uses it yet. Without sources, every
+// caller gets a source of its own.
+func (s *networkSources) acquire(key string) *networkSource {
+	if s == nil {
+		return &networkSource{key: key}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, ok := s.sources[key]
+	if !ok {
+		src = &networkSource{key: key}
+		s.sources[key] = src
+	}
+	src.users++
+	return src
+}
+
+// release gives up src, it reports whether src was the last user.
+func (s *networkSources) release(src *networkSource) bool {
+	if s == nil {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src.users--
+	if src.users > 0 {
+		return false
+	}
+	delete(s.sources, src.key)
+	return true
+}
+
+// fetch returns the data of networkID, fetched with b. When the last fetch started at or after since
+// and the network is not due for the given refresh interval, its result is returned instead.
+func (src *networkSource) fetch(ctx context.Context, b Backend, networkID string, since, now time.Time, refresh time.Duration, backoff Backoff) *fetchResult {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if last := src.last; last != nil && !last.started.Before(since) && now.Before(last.next(refresh)) {
+		return last
+	}
+
+	r := &fetchResult{started: now}
+	r.info, r.members, r.err = fetchNetwork(ctx, b, networkID)
+	if r.err != nil {
+		refreshFailureCount.WithLabelValues(networkID).Inc()
+		src.failures++
+		r.retry = backoff.delay(src.failures)
+	} else {
+		if src.failures > 0 {
+			log.Infof("Network %s recovered after %d failed refreshes", networkID, src.failures)
+		}
+		src.failures = 0
+		refreshSuccessCount.WithLabelValues(networkID).Inc()
+		lastSuccessTime.WithLabelValues(networkID).Set(float64(now.Unix()))
+	}
+	r.failures = src.failures
+	src.last = r
+	return r
+}
+
+// next returns the time the network is due again for refresh.
+func (r *fetchResult) next(refresh time.Duration) time.Time {
+	if r.err != nil && r.retry > 0 {
+		return r.started.Add(r.retry)
+	}
+	return r.started.Add(refresh)
+}
+
+// handOver returns the network data of p that cfg can take over: the networks fetched from the same
+// endpoint with the same credentials, and discovered networks when cfg discovers them with the same
+// template. It returns nil when p has not refreshed yet.
+func (p *poller) handOver(cfg *Config) *snapshot {
+	last := p.cache.lastGood()
+	if last == nil {
+		return nil
+	}
+	sameDiscovery := cfg.Discover != nil && p.cfg.Discover != nil && cfg.Discover.Root.String() == p.cfg.Discover.Root.String()
+	s := &snapshot{Version: last.Version, Written: last.Written}
+	if sameDiscovery {
+		s.Discovered, s.DiscoverSynced = last.Discovered, last.DiscoverSynced
+	}
+	for _, sn := range last.Networks {
+		if p.cfg.endpoint(sn.ID) != cfg.endpoint(sn.ID) || (sn.Zone != "" && !sameDiscovery) {
+			continue
+		}
+		s.Networks = append(s.Networks, sn)
+	}
+	return s
+}
@@ -0,0 +1,223 @@
+package ztnet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+)
+
+func newRegistryServer(t *testing.T, down *atomic.Bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body := `{"v6AssignMode":{"6plane":false,"rfc4193":false}}`
+		if strings.HasSuffix(r.URL.Path, "/member/") {
+			body = `[{"id":"efcc1b0947","name":"node","authorized":true,"ipAssignments":["10.0.0.2"]}]`
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Errorf("write response: %v", err)
+		}
+	}))
+}
+
+// setupPoller parses input like setup does and returns the poller of c.
+func setupPoller(t *testing.T, c *caddy.Controller) (*poller, *ZTNet) {
+	cfg, ft, err := parseConfig(c)
+	if err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	p := newPoller(cfg)
+	return p, &ZTNet{Config: cfg, Cache: p.cache, Backend: p.backend, Fall: ft}
+}
+
+func waitForRecord(t *testing.T, rc *RecordCache, name string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for rc.lastGood() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for refresh")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := rc.Lookup(name); !ok {
+		t.Fatalf("expected record %s", name)
+	}
+}
+
+func TestRegistrySharedSources(t *testing.T) {
+	var memberCalls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := `{"v6AssignMode":{"6plane":false,"rfc4193":false}}`
+		if strings.HasSuffix(r.URL.Path, "/member/") {
+			memberCalls.Add(1)
+			body = `[{"id":"efcc1b0947","name":"node","authorized":true,"ipAssignments":["10.0.0.2"]}]`
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Errorf("write response: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	input := `ztnet {
+		endpoint ` + ts.URL + `
+		token t
+		network home.lan:8056c2e21c000001
+	}`
+	// Two server blocks, like those of two views, that only differ in fallthrough.
+	p1, z1 := setupPoller(t, caddy.NewTestController("dns", input))
+	p2, z2 := setupPoller(t, caddy.NewTestController("dns", strings.Replace(input, "token t", "token t\nfallthrough", 1)))
+	if z1.Fall.Through("node.home.lan.") || !z2.Fall.Through("node.home.lan.") {
+		t.Fatal("expected fallthrough to be configured per server block")
+	}
+
+	if err := pollers.start(p1); err != nil {
+		t.Fatalf("start error: %v", err)
+	}
+	if err := pollers.start(p2); err != nil {
+		t.Fatalf("start error: %v", err)
+	}
+	waitForRecord(t, p1.cache, "node.home.lan.")
+	waitForRecord(t, p2.cache, "node.home.lan.")
+	if n := memberCalls.Load(); n != 1 {
+		t.Fatalf("expected the network to be fetched once, got %d fetches", n)
+	}
+
+	// An event that reaches both server blocks refreshes the network once.
+	at := time.Now()
+	p1.cache.Trigger("8056c2e21c000001", at)
+	p2.cache.Trigger("8056c2e21c000001", at)
+	deadline := time.Now().Add(5 * time.Second)
+	for p1.cache.LastSync().Before(at) || p2.cache.LastSync().Before(at) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for refresh")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := memberCalls.Load(); n != 2 {
+		t.Fatalf("expected the network to be fetched once per refresh, got %d fetches", n)
+	}
+
+	pollers.stop(p1)
+	pollers.stop(p2)
+	key := p1.cfg.endpoint("8056c2e21c000001") + " 8056c2e21c000001"
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		sources.mu.Lock()
+		_, ok := sources.sources[key]
+		sources.mu.Unlock()
+		if !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the source to be released without users")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRegistrySourcesPerCredentials(t *testing.T) {
+	parse := func(input string) *Config {
+		cfg, _, err := parseConfig(caddy.NewTestController("dns", input))
+		if err != nil {
+			t.Fatalf("parseConfig error: %v", err)
+		}
+		return cfg
+	}
+	base := parse(`ztnet {
+		endpoint http://ztnet.example:3000
+		token t
+		network home.lan:8056c2e21c000001
+	}`)
+	for _, input := range []string{
+		`ztnet {
+			endpoint http://ztnet.example:3000
+			token other
+			network home.lan:8056c2e21c000001
+		}`,
+		`ztnet {
+			endpoint http://ztnet.example:3000
+			token t
+			org acme
+			network home.lan:8056c2e21c000001
+		}`,
+	} {
+		if cfg := parse(input); cfg.endpoint("8056c2e21c000001") == base.endpoint("8056c2e21c000001") {
+			t.Errorf("expected another source for %s", input)
+		}
+	}
+	if strings.Contains(base.endpoint("8056c2e21c000001"), " t ") {
+		t.Error("did not expect the token in the source key")
+	}
+}
+
+func TestRegistryHandOver(t *testing.T) {
+	var down atomic.Bool
+	ts := newRegistryServer(t, &down)
+	defer ts.Close()
+
+	input := `ztnet {
+		endpoint ` + ts.URL + `
+		token t
+		network home.lan:8056c2e21c000001
+		snapshot ` + filepath.Join(t.TempDir(), "ztnet.json") + `
+	}`
+	old, _ := setupPoller(t, caddy.NewTestController("dns", input))
+	if err := pollers.start(old); err != nil {
+		t.Fatalf("acquire error: %v", err)
+	}
+	defer pollers.stop(old)
+	waitForRecord(t, old.cache, "node.home.lan.")
+	if _, err := os.Stat(old.cfg.Snapshot); err != nil {
+		t.Fatalf("expected snapshot to be written: %v", err)
+	}
+
+	// A reload that changes the configuration, while the API is down.
+	down.Store(true)
+	p, _ := setupPoller(t, caddy.NewTestController("dns", `ztnet {
+		endpoint `+ts.URL+`
+		token t
+		network home.lan:8056c2e21c000001
+		network work.lan:abcdef01234567aa
+		names {{.Name}}-{{.NetworkShortID}}
+	}`))
+	if err := pollers.start(p); err != nil {
+		t.Fatalf("acquire error: %v", err)
+	}
+	defer pollers.stop(p)
+	if _, ok := p.cache.Lookup("node-000001.home.lan."); !ok {
+		t.Fatal("expected records built from the data of the previous poller")
+	}
+
+	// The data of the previous poller was fetched from the API, so it counts as sync, also when the snapshot
+	// has the same data.
+	same, _ := setupPoller(t, caddy.NewTestController("dns", input))
+	if err := pollers.start(same); err != nil {
+		t.Fatalf("acquire error: %v", err)
+	}
+	defer pollers.stop(same)
+	if same.cache.LastSync().IsZero() {
+		t.Fatal("expected the data of the previous poller to count as sync")
+	}
+
+	// Networks of another endpoint are not taken over.
+	other, _ := setupPoller(t, caddy.NewTestController("dns", `ztnet {
+		endpoint http://localhost:1
+		token t
+		network home.lan:8056c2e21c000001
+	}`))
+	if err := pollers.start(other); err != nil {
+		t.Fatalf("acquire error: %v", err)
+	}
+	defer pollers.stop(other)
+	if _, ok := other.cache.Lookup("node.home.lan."); ok {
+		t.Fatal("did not expect records of another endpoint")
+	}
+}
@@ -0,0 +1,36 @@
+package ztnet
+
+import (
+	"net"
+
+	"github.com/coredns/coredns/plugin/pkg/cidr"
+)
+
+// reverseZones returns the reverse zones covering the member addresses of a network: its
+// managed routes and, when enabled, the RFC4193 (/88) and 6PLANE (/40) prefixes.
+func reverseZones(networkID string, info *NetworkInfo) []string {
+	var zones []string
+	for _, n := range networkPrefixes(networkID, info) {
+		zones = append(zones, cidr.Reverse(cidr.Split(n))...)
+	}
+	return zones
+}
+
+// networkPrefixes returns the managed routes of a network and, when enabled, its RFC4193 (/88) and
+// 6PLANE (/40) prefixes.
+func networkPrefixes(networkID string, info *NetworkInfo) []*net.IPNet {
+	nets := append([]*net.IPNet{}, info.Routes...)
+	if info.RFC4193 {
+		if ip, err := RFC4193(networkID, "0000000000"); err == nil {
+			mask := net.CIDRMask(88, 128)
+			nets = append(nets, &net.IPNet{IP: ip.Mask(mask), Mask: mask})
+		}
+	}
+	if info.SixPlane {
+		if ip, err := SixPlane(networkID, "0000000000"); err == nil {
+			mask := net.CIDRMask(40, 128)
+			nets = append(nets, &net.IPNet{IP: ip.Mask(mask), Mask: mask})
+		}
+	}
+	return nets
+}
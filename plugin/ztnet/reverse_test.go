@@ -0,0 +1,33 @@
+package ztnet
+
+import (
+	"net"
+	"testing"
+)
+
+func TestReverseZones(t *testing.T) {
+	_, route, _ := net.ParseCIDR("10.147.16.0/23")
+	info := &NetworkInfo{RFC4193: true, SixPlane: true, Routes: []*net.IPNet{route}}
+
+	got := reverseZones("8056c2e21c000001", info)
+	want := []string{
+		"16.147.10.in-addr.arpa.",
+		"17.147.10.in-addr.arpa.",
+		"3.9.9.9.1.0.0.0.0.0.c.1.2.e.2.c.6.5.0.8.d.f.ip6.arpa.",
+		"3.e.2.c.6.5.c.9.c.f.ip6.arpa.",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+}
+
+func TestReverseZonesNone(t *testing.T) {
+	if got := reverseZones("8056c2e21c000001", &NetworkInfo{}); len(got) != 0 {
+		t.Fatalf("expected no reverse zones, got %v", got)
+	}
+}
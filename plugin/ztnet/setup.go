@@ -1,23 +1,35 @@
 package ztnet
 
 import (
-	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
 	"github.com/coredns/coredns/plugin/pkg/fall"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
+	mwtls "github.com/coredns/coredns/plugin/pkg/tls"
+	"github.com/coredns/coredns/plugin/transfer"
 )
 
 var (
 	log       = clog.NewWithPlugin("ztnet")
 	zoneRegex = regexp.MustCompile(`^([a-z0-9]([a-z0-9\-]{0,61}[a-z0-9])?\.)+[a-z]{2,}$`)
+	// networkIDRegex matches a ZeroTier network ID, it tells a network apart from a names template.
+	networkIDRegex = regexp.MustCompile(`^[0-9a-fA-F]{16}$`)
 )
 
 // init registers the plugin with the CoreDNS plugin system.
@@ -29,19 +41,25 @@ func setup(c *caddy.Controller) error {
 		return plugin.Error("ztnet", err)
 	}
 
-	z := &ZTNet{Config: cfg, Cache: &RecordCache{}, Client: NewClient(cfg.APIAddress, cfg.APIToken), Fall: ft}
+	p := newPoller(cfg)
+	z := &ZTNet{Config: cfg, Cache: p.cache, Backend: p.backend, Fall: ft}
+	p.cache.onUpdate = func(zones []string) { go z.notify(zones) }
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		z.Next = next
 		return z
 	})
 
-	ctx, cancel := context.WithCancel(context.Background())
 	c.OnStartup(func() error {
-		go z.Cache.refreshLoop(ctx, z.Client, z.Config)
-		return nil
+		// get the transfer plugin, so we can send notifies when the records change.
+		if t := dnsserver.GetConfig(c).Handler("transfer"); t != nil {
+			z.transfer = t.(*transfer.Transfer) // if found this must be OK.
+		}
+		z.localIPs = boundIPs(c)
+		p.cache.servers = serverAddrs(c)
+		return pollers.start(p)
 	})
 	c.OnShutdown(func() error {
-		cancel()
+		pollers.stop(p)
 		return nil
 	})
 
@@ -49,13 +67,34 @@ func setup(c *caddy.Controller) error {
 }
 
 func parseConfig(c *caddy.Controller) (*Config, fall.F, error) {
-	cfg := &Config{RefreshTTL: DefaultRefreshTTL, DNSTTL: DefaultDNSTTL}
+	cfg := &Config{
+		Backend:     BackendZTNET,
+		Duplicates:  DuplicateMerge,
+		RefreshTTL:  DefaultRefreshTTL,
+		DNSTTL:      DefaultDNSTTL,
+		HTTPTimeout: DefaultHTTPTimeout,
+		Backoff:     Backoff{Base: DefaultBackoffBase, Max: DefaultBackoffMax, Jitter: DefaultBackoffJitter},
+	}
 	ft := fall.Zero
 	networkCount := 0
+	tlsServerName := ""
+	networkNames := make(map[string][]*template.Template)
+	networkIPv6 := make(map[string]*IPv6Sources)
 
 	for c.Next() {
 		for c.NextBlock() {
 			switch c.Val() {
+			case "backend":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, fall.Zero, c.Errf("backend requires exactly one value")
+				}
+				switch args[0] {
+				case BackendZTNET, BackendController:
+					cfg.Backend = args[0]
+				default:
+					return nil, fall.Zero, c.Errf("unknown backend %q, must be %s or %s", args[0], BackendZTNET, BackendController)
+				}
 			case "endpoint":
 				args := c.RemainingArgs()
 				if len(args) != 1 {
@@ -68,29 +107,98 @@ func parseConfig(c *caddy.Controller) (*Config, fall.F, error) {
 					return nil, fall.Zero, c.Errf("token requires exactly one value")
 				}
 				cfg.APIToken = args[0]
-			case "network":
+			case "token_file":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, fall.Zero, c.Errf("token_file requires exactly one path")
+				}
+				cfg.APITokenFile = args[0]
+			case "tls": // cert key cacertfile
+				args := c.RemainingArgs()
+				for i := range args {
+					if !filepath.IsAbs(args[i]) && dnsserver.GetConfig(c).Root != "" {
+						args[i] = filepath.Join(dnsserver.GetConfig(c).Root, args[i])
+					}
+				}
+				tlsConfig, err := mwtls.NewTLSConfigFromArgs(args...)
+				if err != nil {
+					return nil, fall.Zero, c.Errf("invalid tls: %v", err)
+				}
+				cfg.TLS = tlsConfig
+			case "tls_servername":
 				args := c.RemainingArgs()
 				if len(args) != 1 {
-					return nil, fall.Zero, c.Errf("network requires zone:networkID")
+					return nil, fall.Zero, c.Errf("tls_servername requires exactly one value")
 				}
-				parts := strings.Split(args[0], ":")
-				if len(parts) != 2 {
-					return nil, fall.Zero, c.Errf("network must be zone:networkID")
+				tlsServerName = args[0]
+			case "proxy":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, fall.Zero, c.Errf("proxy requires exactly one URL")
 				}
-				zone := strings.ToLower(parts[0])
-				if !zoneRegex.MatchString(zone) {
-					return nil, fall.Zero, c.Errf("invalid zone name %q", zone)
+				u, err := url.Parse(args[0])
+				if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+					return nil, fall.Zero, c.Errf("invalid proxy URL %q", args[0])
+				}
+				cfg.Proxy = u
+			case "timeout":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, fall.Zero, c.Errf("timeout requires duration")
 				}
-				zone = strings.TrimSuffix(zone, ".") + "."
-				cfg.Networks = append(cfg.Networks, NetworkZone{Zone: zone, NetworkID: strings.ToLower(parts[1])})
+				d, err := time.ParseDuration(args[0])
+				if err != nil || d <= 0 {
+					return nil, fall.Zero, c.Errf("invalid timeout duration %q", args[0])
+				}
+				cfg.HTTPTimeout = d
+			case "network":
+				nz, err := parseNetwork(c)
+				if err != nil {
+					return nil, fall.Zero, err
+				}
+				cfg.Networks = append(cfg.Networks, nz)
 				networkCount++
+			case "org":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, fall.Zero, c.Errf("org requires exactly one value")
+				}
+				cfg.Org = args[0]
+			case "discover":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, fall.Zero, c.Errf("discover requires a zone template")
+				}
+				tmpl, err := parseZoneTemplate(args[0])
+				if err != nil {
+					return nil, fall.Zero, c.Errf("invalid discover template %q: %v", args[0], err)
+				}
+				cfg.Discover = tmpl
+			case "central_endpoint":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, fall.Zero, c.Errf("central_endpoint requires exactly one value")
+				}
+				cfg.CentralAddress = args[0]
+			case "central_token":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, fall.Zero, c.Errf("central_token requires exactly one value")
+				}
+				cfg.CentralToken = args[0]
+			case "central_token_file":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, fall.Zero, c.Errf("central_token_file requires exactly one path")
+				}
+				cfg.CentralTokenFile = args[0]
 			case "refresh":
 				args := c.RemainingArgs()
 				if len(args) != 1 {
 					return nil, fall.Zero, c.Errf("refresh requires duration")
 				}
 				d, err := time.ParseDuration(args[0])
-				if err != nil {
+				if err != nil || d <= 0 {
 					return nil, fall.Zero, c.Errf("invalid refresh duration %q", args[0])
 				}
 				cfg.RefreshTTL = d
@@ -104,6 +212,216 @@ func parseConfig(c *caddy.Controller) (*Config, fall.F, error) {
 					return nil, fall.Zero, c.Errf("invalid dns_ttl duration %q", args[0])
 				}
 				cfg.DNSTTL = d
+			case "backoff":
+				args := c.RemainingArgs()
+				if len(args) != 2 && len(args) != 3 {
+					return nil, fall.Zero, c.Errf("backoff requires base and max durations and an optional jitter")
+				}
+				base, err := time.ParseDuration(args[0])
+				if err != nil || base <= 0 {
+					return nil, fall.Zero, c.Errf("invalid backoff base duration %q", args[0])
+				}
+				maxDelay, err := time.ParseDuration(args[1])
+				if err != nil || maxDelay < base {
+					return nil, fall.Zero, c.Errf("invalid backoff max duration %q", args[1])
+				}
+				cfg.Backoff.Base, cfg.Backoff.Max = base, maxDelay
+				if len(args) == 3 {
+					jitter, err := strconv.ParseFloat(args[2], 64)
+					if err != nil || jitter < 0 || jitter > 1 {
+						return nil, fall.Zero, c.Errf("invalid backoff jitter %q, must be between 0 and 1", args[2])
+					}
+					cfg.Backoff.Jitter = jitter
+				}
+			case "max_stale":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, fall.Zero, c.Errf("max_stale requires duration")
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil || d < 0 {
+					return nil, fall.Zero, c.Errf("invalid max_stale duration %q", args[0])
+				}
+				cfg.MaxStale = d
+			case "snapshot":
+				args := c.RemainingArgs()
+				if len(args) != 1 && len(args) != 2 {
+					return nil, fall.Zero, c.Errf("snapshot requires a path and an optional maximum age")
+				}
+				if fi, err := os.Stat(filepath.Dir(args[0])); err != nil || !fi.IsDir() {
+					return nil, fall.Zero, c.Errf("snapshot directory of %q does not exist", args[0])
+				}
+				cfg.Snapshot = args[0]
+				if len(args) == 2 {
+					d, err := time.ParseDuration(args[1])
+					if err != nil || d <= 0 {
+						return nil, fall.Zero, c.Errf("invalid snapshot maximum age %q", args[1])
+					}
+					cfg.SnapshotMaxAge = d
+				}
+			case "webhook":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, fall.Zero, c.Errf("webhook requires a listen address")
+				}
+				if _, _, err := net.SplitHostPort(args[0]); err != nil {
+					return nil, fall.Zero, c.Errf("invalid webhook address %q: %v", args[0], err)
+				}
+				cfg.WebhookAddr = args[0]
+			case "webhook_secret":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, fall.Zero, c.Errf("webhook_secret requires exactly one value")
+				}
+				cfg.WebhookSecret = args[0]
+			case "unhealthy_after":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, fall.Zero, c.Errf("unhealthy_after requires duration")
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil || d < 0 {
+					return nil, fall.Zero, c.Errf("invalid unhealthy_after duration %q", args[0])
+				}
+				cfg.UnhealthyAfter = d
+			case "names":
+				args := c.RemainingArgs()
+				networkID := ""
+				if len(args) > 0 && networkIDRegex.MatchString(args[0]) {
+					networkID, args = strings.ToLower(args[0]), args[1:]
+				}
+				if len(args) == 0 {
+					return nil, fall.Zero, c.Errf("names requires at least one template")
+				}
+				tmpls, err := parseNameTemplates(args)
+				if err != nil {
+					return nil, fall.Zero, c.Errf("invalid names template: %v", err)
+				}
+				if networkID == "" {
+					cfg.Names = tmpls
+				} else {
+					networkNames[networkID] = tmpls
+				}
+			case "ipv6":
+				args := c.RemainingArgs()
+				networkID := ""
+				if len(args) > 0 && networkIDRegex.MatchString(args[0]) {
+					networkID, args = strings.ToLower(args[0]), args[1:]
+				}
+				sources, err := parseIPv6Sources(args)
+				if err != nil {
+					return nil, fall.Zero, c.Errf("%v", err)
+				}
+				if networkID == "" {
+					cfg.IPv6 = sources
+				} else {
+					networkIPv6[networkID] = sources
+				}
+			case "idna":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, fall.Zero, c.ArgErr()
+				}
+				cfg.IDNA = true
+			case "duplicates":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, fall.Zero, c.Errf("duplicates requires exactly one value")
+				}
+				switch args[0] {
+				case DuplicateMerge, DuplicateFirst, DuplicateSuffix, DuplicateSkip:
+					cfg.Duplicates = args[0]
+				default:
+					return nil, fall.Zero, c.Errf("unknown duplicates policy %q, must be %s, %s, %s or %s", args[0], DuplicateMerge, DuplicateFirst, DuplicateSuffix, DuplicateSkip)
+				}
+			case "online_within":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, fall.Zero, c.Errf("online_within requires duration")
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil || d <= 0 {
+					return nil, fall.Zero, c.Errf("invalid online_within duration %q", args[0])
+				}
+				cfg.Filter.OnlineWithin = d
+			case "pending":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, fall.Zero, c.Errf("pending requires a label")
+				}
+				label := sanitizeLabel(args[0], false)
+				if label != args[0] {
+					return nil, fall.Zero, c.Errf("invalid pending label %q", args[0])
+				}
+				cfg.Filter.Pending = label
+			case "exclude_tag":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, fall.Zero, c.Errf("exclude_tag requires at least one tag")
+				}
+				cfg.Filter.ExcludeTags = append(cfg.Filter.ExcludeTags, args...)
+			case "exclude_name":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, fall.Zero, c.Errf("exclude_name requires a regular expression")
+				}
+				re, err := regexp.Compile(args[0])
+				if err != nil {
+					return nil, fall.Zero, c.Errf("invalid exclude_name expression %q: %v", args[0], err)
+				}
+				cfg.Filter.ExcludeName = re
+			case "wildcard":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, fall.Zero, c.ArgErr()
+				}
+				cfg.Wildcard = true
+			case "members_only":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, fall.Zero, c.ArgErr()
+				}
+				cfg.MembersOnly = true
+			case "groups":
+				args := c.RemainingArgs()
+				if len(args) > 1 {
+					return nil, fall.Zero, c.ArgErr()
+				}
+				cfg.Groups = DefaultGroupsLabel
+				if len(args) == 1 {
+					label := sanitizeLabel(args[0], false)
+					if label != args[0] {
+						return nil, fall.Zero, c.Errf("invalid groups label %q", args[0])
+					}
+					cfg.Groups = label
+				}
+			case "group_tag":
+				args := c.RemainingArgs()
+				if len(args) < 2 {
+					return nil, fall.Zero, c.Errf("group_tag requires a group and at least one tag")
+				}
+				group := sanitizeLabel(args[0], false)
+				if group != args[0] {
+					return nil, fall.Zero, c.Errf("invalid group %q", args[0])
+				}
+				if cfg.GroupTags == nil {
+					cfg.GroupTags = make(map[string][]string)
+				}
+				cfg.GroupTags[group] = append(cfg.GroupTags[group], args[1:]...)
+			case "reverse":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, fall.Zero, c.Errf("reverse requires at least one zone or CIDR")
+				}
+				for _, arg := range args {
+					zones := plugin.Host(arg).NormalizeExact()
+					if len(zones) == 0 || dnsutil.IsReverse(zones[0]) == 0 {
+						return nil, fall.Zero, c.Errf("invalid reverse zone %q", arg)
+					}
+					cfg.ReverseZones = append(cfg.ReverseZones, zones...)
+				}
+			case "no_reverse":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, fall.Zero, c.ArgErr()
+				}
+				cfg.NoReverse = true
 			case "fallthrough":
 				ft.SetZonesFromArgs(c.RemainingArgs())
 			default:
@@ -112,18 +430,336 @@ func parseConfig(c *caddy.Controller) (*Config, fall.F, error) {
 		}
 	}
 
-	if cfg.APIAddress == "" {
-		return nil, fall.Zero, fmt.Errorf("endpoint is required")
+	if networkCount == 0 && cfg.Discover == nil {
+		return nil, fall.Zero, fmt.Errorf("at least one network must be configured (or use discover)")
 	}
-	if cfg.APIToken == "" {
-		cfg.APIToken = os.Getenv("ZTNET_API_TOKEN")
+	if (cfg.Org != "" || cfg.Discover != nil) && cfg.Backend != BackendZTNET {
+		return nil, fall.Zero, fmt.Errorf("org and discover require the %s backend", BackendZTNET)
 	}
-	if cfg.APIToken == "" {
-		return nil, fall.Zero, fmt.Errorf("token is required (or set ZTNET_API_TOKEN)")
+
+	for networkID, tmpls := range networkNames {
+		i := slices.IndexFunc(cfg.Networks, func(nz NetworkZone) bool { return nz.NetworkID == networkID })
+		if i < 0 {
+			return nil, fall.Zero, fmt.Errorf("names: network %s is not configured", networkID)
+		}
+		cfg.Networks[i].Names = tmpls
 	}
-	if networkCount == 0 {
-		return nil, fall.Zero, fmt.Errorf("at least one network must be configured")
+	for networkID, sources := range networkIPv6 {
+		i := slices.IndexFunc(cfg.Networks, func(nz NetworkZone) bool { return nz.NetworkID == networkID })
+		if i < 0 {
+			return nil, fall.Zero, fmt.Errorf("ipv6: network %s is not configured", networkID)
+		}
+		cfg.Networks[i].IPv6 = sources
+	}
+
+	if tlsServerName != "" {
+		if cfg.TLS == nil {
+			tlsConfig, err := mwtls.NewTLSClientConfig("")
+			if err != nil {
+				return nil, fall.Zero, err
+			}
+			cfg.TLS = tlsConfig
+		}
+		cfg.TLS.ServerName = tlsServerName
+	}
+	if cfg.APIToken != "" && cfg.APITokenFile != "" {
+		return nil, fall.Zero, fmt.Errorf("token and token_file are mutually exclusive")
+	}
+	if cfg.CentralToken != "" && cfg.CentralTokenFile != "" {
+		return nil, fall.Zero, fmt.Errorf("central_token and central_token_file are mutually exclusive")
+	}
+
+	// endpoint and token belong to the default backend, the only other backend a network can use is Central.
+	// They are only required when a network does not have its own.
+	needAddress, needToken := cfg.Discover != nil, cfg.Discover != nil
+	needCentralToken := false
+	for i, nz := range cfg.Networks {
+		hasToken := nz.APIToken != "" || nz.APITokenFile != ""
+		if nz.APIToken != "" && nz.APITokenFile != "" {
+			return nil, fall.Zero, fmt.Errorf("network %s: token and token_file are mutually exclusive", nz.NetworkID)
+		}
+		switch nz.Backend {
+		case "", cfg.Backend:
+			cfg.Networks[i].Backend = cfg.Backend
+			needAddress = needAddress || nz.APIAddress == ""
+			needToken = needToken || !hasToken
+		case BackendCentral:
+			needCentralToken = needCentralToken || !hasToken
+		default:
+			return nil, fall.Zero, fmt.Errorf("network %s: backend must be %s or %s", nz.NetworkID, cfg.Backend, BackendCentral)
+		}
+	}
+	if cfg.CentralAddress == "" {
+		cfg.CentralAddress = DefaultCentralAddress
+	}
+	if needCentralToken && cfg.CentralToken == "" && cfg.CentralTokenFile == "" {
+		cfg.CentralToken = os.Getenv("ZEROTIER_CENTRAL_TOKEN")
+		if cfg.CentralToken == "" {
+			return nil, fall.Zero, fmt.Errorf("central_token is required (or set ZEROTIER_CENTRAL_TOKEN)")
+		}
+	}
+
+	switch cfg.Backend {
+	case BackendController:
+		if cfg.APIAddress == "" {
+			cfg.APIAddress = DefaultControllerAddress
+		}
+		if needToken && cfg.APIToken == "" && cfg.APITokenFile == "" {
+			cfg.APITokenFile = DefaultControllerTokenFile
+		}
+	default:
+		if needAddress && cfg.APIAddress == "" {
+			return nil, fall.Zero, fmt.Errorf("endpoint is required")
+		}
+		if needToken && cfg.APIToken == "" && cfg.APITokenFile == "" {
+			cfg.APIToken = os.Getenv("ZTNET_API_TOKEN")
+			if cfg.APIToken == "" {
+				return nil, fall.Zero, fmt.Errorf("token is required (or set ZTNET_API_TOKEN)")
+			}
+		}
+	}
+
+	// Networks without their own endpoint or token use the ones of their backend.
+	for i, nz := range cfg.Networks {
+		address, token, tokenFile := cfg.APIAddress, cfg.APIToken, cfg.APITokenFile
+		if nz.Backend == BackendCentral {
+			address, token, tokenFile = cfg.CentralAddress, cfg.CentralToken, cfg.CentralTokenFile
+		}
+		if nz.APIAddress == "" {
+			cfg.Networks[i].APIAddress = address
+		}
+		if nz.APIToken == "" && nz.APITokenFile == "" {
+			cfg.Networks[i].APIToken, cfg.Networks[i].APITokenFile = token, tokenFile
+		}
+	}
+	// Token files are read again on every change, they must be usable from the start.
+	var tokenFiles []string
+	if needToken {
+		tokenFiles = append(tokenFiles, cfg.APITokenFile)
+	}
+	for _, nz := range cfg.Networks {
+		tokenFiles = append(tokenFiles, nz.APITokenFile)
+	}
+	for _, path := range tokenFiles {
+		if path == "" {
+			continue
+		}
+		if _, err := fileToken(path).get(false); err != nil {
+			return nil, fall.Zero, fmt.Errorf("%w (or set token)", err)
+		}
+	}
+
+	if cfg.WebhookSecret == "" {
+		cfg.WebhookSecret = os.Getenv("ZTNET_WEBHOOK_SECRET")
+	}
+	if cfg.WebhookAddr != "" && cfg.WebhookSecret == "" {
+		return nil, fall.Zero, fmt.Errorf("webhook_secret is required with webhook (or set ZTNET_WEBHOOK_SECRET)")
+	}
+	if cfg.NoReverse && len(cfg.ReverseZones) > 0 {
+		return nil, fall.Zero, fmt.Errorf("reverse and no_reverse are mutually exclusive")
+	}
+	if len(cfg.GroupTags) > 0 && cfg.Groups == "" {
+		return nil, fall.Zero, fmt.Errorf("group_tag requires groups")
+	}
+	if cfg.Groups != "" && cfg.Groups == cfg.Filter.Pending {
+		return nil, fall.Zero, fmt.Errorf("groups and pending cannot use the same label %q", cfg.Groups)
 	}
 
 	return cfg, ft, nil
 }
+
+// parseIPv6Sources parses the arguments of ipv6: one or more of assigned, rfc4193 and 6plane, or none.
+func parseIPv6Sources(args []string) (*IPv6Sources, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("ipv6 requires at least one source")
+	}
+	sources := &IPv6Sources{}
+	if len(args) == 1 && args[0] == "none" {
+		return sources, nil
+	}
+	for _, arg := range args {
+		switch arg {
+		case "assigned":
+			sources.Assigned = true
+		case "rfc4193":
+			sources.RFC4193 = true
+		case "6plane":
+			sources.SixPlane = true
+		default:
+			return nil, fmt.Errorf("unknown ipv6 source %q, must be assigned, rfc4193, 6plane or none", arg)
+		}
+	}
+	return sources, nil
+}
+
+// parseNetwork parses a network, either as "network ZONE NETWORKID [BACKEND] [{ ... }]" or as
+// "network ZONE:NETWORKID [BACKEND]".
+func parseNetwork(c *caddy.Controller) (NetworkZone, error) {
+	args := c.RemainingArgs()
+	if len(args) > 0 {
+		if zone, networkID, ok := strings.Cut(args[0], ":"); ok {
+			args = append([]string{zone, networkID}, args[1:]...)
+		}
+	}
+	if len(args) != 2 && len(args) != 3 {
+		return NetworkZone{}, c.Errf("network requires a zone, a network ID and an optional backend")
+	}
+	zone := strings.ToLower(args[0])
+	if !zoneRegex.MatchString(strings.TrimSuffix(zone, ".")) {
+		return NetworkZone{}, c.Errf("invalid zone name %q", zone)
+	}
+	if !networkIDRegex.MatchString(args[1]) {
+		return NetworkZone{}, c.Errf("invalid network ID %q", args[1])
+	}
+	nz := NetworkZone{Zone: strings.TrimSuffix(zone, ".") + ".", NetworkID: strings.ToLower(args[1])}
+	if len(args) == 3 {
+		switch args[2] {
+		case BackendZTNET, BackendController, BackendCentral:
+			nz.Backend = args[2]
+		default:
+			return NetworkZone{}, c.Errf("unknown backend %q for network %s", args[2], nz.NetworkID)
+		}
+	}
+
+	if !c.NextArg() {
+		return nz, nil
+	}
+	if c.Val() != "{" {
+		return NetworkZone{}, c.Errf("unexpected %q after network %s", c.Val(), nz.NetworkID)
+	}
+	for c.Next() {
+		if c.Val() == "}" {
+			return nz, nil
+		}
+		switch c.Val() {
+		case "endpoint":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return NetworkZone{}, c.Errf("endpoint requires exactly one value")
+			}
+			nz.APIAddress = args[0]
+		case "token":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return NetworkZone{}, c.Errf("token requires exactly one value")
+			}
+			nz.APIToken = args[0]
+		case "token_file":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return NetworkZone{}, c.Errf("token_file requires exactly one path")
+			}
+			nz.APITokenFile = args[0]
+		case "ttl":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return NetworkZone{}, c.Errf("ttl requires duration")
+			}
+			d, err := time.ParseDuration(args[0])
+			if err != nil || d <= 0 {
+				return NetworkZone{}, c.Errf("invalid ttl duration %q", args[0])
+			}
+			nz.DNSTTL = d
+		case "refresh":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return NetworkZone{}, c.Errf("refresh requires duration")
+			}
+			d, err := time.ParseDuration(args[0])
+			if err != nil || d <= 0 {
+				return NetworkZone{}, c.Errf("invalid refresh duration %q", args[0])
+			}
+			nz.RefreshTTL = d
+		case "names":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return NetworkZone{}, c.Errf("names requires at least one template")
+			}
+			tmpls, err := parseNameTemplates(args)
+			if err != nil {
+				return NetworkZone{}, c.Errf("invalid names template: %v", err)
+			}
+			nz.Names = tmpls
+		case "ipv6":
+			sources, err := parseIPv6Sources(c.RemainingArgs())
+			if err != nil {
+				return NetworkZone{}, c.Errf("%v", err)
+			}
+			nz.IPv6 = sources
+		case "members_only":
+			if len(c.RemainingArgs()) != 0 {
+				return NetworkZone{}, c.ArgErr()
+			}
+			nz.MembersOnly = true
+		default:
+			return NetworkZone{}, c.Errf("unknown network property %q", c.Val())
+		}
+	}
+	return NetworkZone{}, c.Errf("unterminated block of network %s", nz.NetworkID)
+}
+
+// newBackend returns a Backend that dispatches every network to its API client. Networks that use the
+// same API with the same token share a client.
+func newBackend(cfg *Config) Backend {
+	type clientKey struct{ backend, address, token, tokenFile, org string }
+	clients := make(map[clientKey]Backend)
+	client := func(key clientKey) Backend {
+		if b, ok := clients[key]; ok {
+			return b
+		}
+		token := staticToken(key.token)
+		if key.tokenFile != "" {
+			token = fileToken(key.tokenFile)
+		}
+		b := newClient(cfg, key.backend, key.address, key.org, token)
+		clients[key] = b
+		return b
+	}
+
+	backends := &networkBackends{networks: make(map[string]Backend)}
+	for _, nz := range cfg.Networks {
+		backends.networks[nz.NetworkID] = client(clientKey{nz.Backend, nz.APIAddress, nz.APIToken, nz.APITokenFile, cfg.org(nz.Backend, nz.APIAddress)})
+	}
+	// Discovered networks are fetched from the default backend.
+	if cfg.Discover != nil {
+		backends.def = client(clientKey{cfg.Backend, cfg.APIAddress, cfg.APIToken, cfg.APITokenFile, cfg.org(cfg.Backend, cfg.APIAddress)})
+	}
+	return backends
+}
+
+// newClient returns the API client of backend for address and token, org only applies to ZTNET.
+func newClient(cfg *Config, backend, address, org string, token *tokenSource) Backend {
+	hc := newHTTPClient(cfg, backend)
+	switch backend {
+	case BackendController:
+		c := NewControllerClient(address, "")
+		c.token, c.httpClient = token, hc
+		return c
+	case BackendCentral:
+		c := NewCentralClient(address, "")
+		c.token, c.httpClient = token, hc
+		return c
+	}
+	c := NewClient(address, "")
+	c.token, c.httpClient = token, hc
+	c.org = org
+	return c
+}
+
+// newHTTPClient returns the HTTP client for the API of backend. The TLS configuration only applies to
+// the default backend, Central uses the system roots.
+func newHTTPClient(cfg *Config, backend string) *http.Client {
+	var tlsConfig *tls.Config
+	if backend != BackendCentral && cfg.TLS != nil {
+		tlsConfig = cfg.TLS.Clone()
+	}
+	tr := mwtls.NewHTTPSTransport(tlsConfig)
+	if cfg.Proxy != nil {
+		tr.Proxy = http.ProxyURL(cfg.Proxy)
+	}
+	timeout := cfg.HTTPTimeout
+	if timeout <= 0 {
+		timeout = DefaultHTTPTimeout
+	}
+	return &http.Client{Transport: tr, Timeout: timeout}
+}
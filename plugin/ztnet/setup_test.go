@@ -1,7 +1,22 @@
 package ztnet
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -53,22 +68,636 @@ func TestParseConfigDefaults(t *testing.T) {
 	if cfg.RefreshTTL != DefaultRefreshTTL || cfg.DNSTTL != DefaultDNSTTL {
 		t.Fatalf("expected defaults got %#v", cfg)
 	}
+	if cfg.Backoff != (Backoff{Base: DefaultBackoffBase, Max: DefaultBackoffMax, Jitter: DefaultBackoffJitter}) || cfg.MaxStale != 0 {
+		t.Fatalf("expected default backoff got %#v", cfg)
+	}
 }
 
 func TestParseConfigErrors(t *testing.T) {
 	_ = os.Unsetenv("ZTNET_API_TOKEN")
-	cases := []string{
-		`ztnet { token t network home.lan:abcdef01234567aa }`,
-		`ztnet { endpoint http://localhost:3000 network home.lan:abcdef01234567aa }`,
-		`ztnet { endpoint http://localhost:3000 token t }`,
-		`ztnet { endpoint http://localhost:3000 token t network bad_zone:abcdef01234567aa }`,
-		`ztnet { endpoint http://localhost:3000 token t network home.lan:abcdef01234567aa refresh x }`,
-		`ztnet { endpoint http://localhost:3000 token t network home.lan:abcdef01234567aa dns_ttl x }`,
-	}
-	for _, input := range cases {
-		c := caddy.NewTestController("dns", input)
-		if _, _, err := parseConfig(c); err == nil {
-			t.Fatalf("expected error for %s", input)
+	_ = os.Unsetenv("ZTNET_WEBHOOK_SECRET")
+	_ = os.Unsetenv("ZEROTIER_CENTRAL_TOKEN")
+	cases := []struct{ input, err string }{
+		{"ztnet {\n token t\n network home.lan:abcdef01234567aa\n }", "endpoint is required"},
+		{"ztnet {\n endpoint http://localhost:3000\n network home.lan:abcdef01234567aa\n }", "token is required"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n }", "at least one network must be configured"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n duplicates rename\n }", "unknown duplicates policy"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n idna yes\n }", "Wrong argument count"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n wildcard on\n }", "Wrong argument count"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n online_within 0s\n }", "invalid online_within duration"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n pending pending.sub\n }", "invalid pending label"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n exclude_tag\n }", "exclude_tag requires at least one tag"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n exclude_name [\n }", "invalid exclude_name expression"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n names\n }", "names requires at least one template"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:net1\n }", "invalid network ID"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n members_only yes\n }", "Wrong argument count"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n tls /nonexistent.pem\n }", "invalid tls"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n tls_servername\n }", "tls_servername requires exactly one value"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n proxy localhost:3128\n }", "invalid proxy URL"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n timeout 0s\n }", "invalid timeout duration"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n token_file /nonexistent\n network home.lan:abcdef01234567aa\n }", "token and token_file are mutually exclusive"},
+		{"ztnet {\n endpoint http://localhost:3000\n token_file /nonexistent\n network home.lan:abcdef01234567aa\n }", "/nonexistent"},
+		{"ztnet {\n endpoint http://localhost:3000\n token_file\n network home.lan:abcdef01234567aa\n }", "token_file requires exactly one path"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan\n }", "network requires a zone, a network ID and an optional backend"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan abcdef01234567aa central extra\n }", "network requires a zone, a network ID and an optional backend"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan abcdef01234567aa {\n ttl 0s\n }\n }", "invalid ttl duration"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan abcdef01234567aa {\n refresh soon\n }\n }", "invalid refresh duration"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan abcdef01234567aa {\n fallthrough\n }\n }", "unknown network property \"fallthrough\""},
+		{"ztnet {\n endpoint http://localhost:3000\n network home.lan abcdef01234567aa {\n endpoint http://localhost:3001\n }\n }", "token is required"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n ipv6\n }", "ipv6 requires at least one source"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n ipv6 slaac\n }", "unknown ipv6 source \"slaac\""},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n ipv6 none assigned\n }", "unknown ipv6 source \"none\""},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n ipv6 8056c2e21c000001 assigned\n }", "ipv6: network 8056c2e21c000001 is not configured"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n names {{.Name}\n }", "invalid names template"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n names {{.Owner}}\n }", "invalid names template"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n names -{{.ID}}\n }", "invalid names template"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n names abcdef01234567aa\n }", "names requires at least one template"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n names 8056c2e21c000001 {{.ID}}\n }", "names: network 8056c2e21c000001 is not configured"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network bad_zone:abcdef01234567aa\n }", "invalid zone name"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n refresh x\n }", "invalid refresh duration"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n refresh 0s\n }", "invalid refresh duration"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n refresh -1m\n }", "invalid refresh duration"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n dns_ttl x\n }", "invalid dns_ttl duration"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n reverse home.lan\n }", "invalid reverse zone"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n backoff 10s\n }", "backoff requires base and max durations"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n backoff 10s 5s\n }", "invalid backoff max duration"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n backoff 1s 5s 2\n }", "invalid backoff jitter"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n max_stale -1s\n }", "invalid max_stale duration"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n unhealthy_after x\n }", "invalid unhealthy_after duration"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n webhook :9153\n }", "webhook_secret is required"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n webhook localhost\n webhook_secret s\n }", "invalid webhook address"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n reverse 10.0.0.0/8\n no_reverse\n }", "reverse and no_reverse are mutually exclusive"},
+		{"ztnet {\n backend central\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n }", "unknown backend \"central\""},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa other\n }", "unknown backend \"other\" for network"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa controller\n }", "backend must be ztnet or central"},
+		{"ztnet {\n network home.lan:abcdef01234567aa central\n }", "central_token is required"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n discover {{.Nope}}.zt.example\n }", "invalid discover template"},
+		{"ztnet {\n backend controller\n token t\n discover {{.NetworkName}}.zt.example\n }", "org and discover require"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n groups a b\n }", "Wrong argument count"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n groups Groups\n }", "invalid groups label"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n groups\n group_tag web\n }", "group_tag requires a group and at least one tag"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n groups\n group_tag web.sub 100\n }", "invalid group \"web.sub\""},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n group_tag web 100\n }", "group_tag requires groups"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n groups pending\n pending pending\n }", "groups and pending cannot use the same label"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n snapshot\n }", "snapshot requires a path"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n snapshot /nonexistent/ztnet.json\n }", "snapshot directory of"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n snapshot /tmp/ztnet.json 0s\n }", "invalid snapshot maximum age"},
+		{"ztnet {\n endpoint http://localhost:3000\n token t\n network home.lan:abcdef01234567aa\n snapshot /tmp/ztnet.json 1h extra\n }", "snapshot requires a path"},
+	}
+	for _, tc := range cases {
+		c := caddy.NewTestController("dns", tc.input)
+		_, _, err := parseConfig(c)
+		if err == nil {
+			t.Fatalf("expected error for %q", tc.input)
+		}
+		if !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("expected error containing %q for %q, got %v", tc.err, tc.input, err)
+		}
+	}
+}
+
+func TestParseConfigReverse(t *testing.T) {
+	t.Setenv("ZTNET_API_TOKEN", "env-token")
+	c := caddy.NewTestController("dns", `ztnet {
+		endpoint http://localhost:3000
+		network home.lan:abcdef01234567aa
+		reverse 10.147.16.0/23 fd80::/16
+	}`)
+	cfg, _, err := parseConfig(c)
+	if err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	want := []string{"16.147.10.in-addr.arpa.", "17.147.10.in-addr.arpa.", "0.8.d.f.ip6.arpa."}
+	if len(cfg.ReverseZones) != len(want) {
+		t.Fatalf("want reverse zones %v, got %v", want, cfg.ReverseZones)
+	}
+	for i := range want {
+		if cfg.ReverseZones[i] != want[i] {
+			t.Fatalf("want reverse zones %v, got %v", want, cfg.ReverseZones)
+		}
+	}
+
+	c = caddy.NewTestController("dns", `ztnet {
+		endpoint http://localhost:3000
+		network home.lan:abcdef01234567aa
+		no_reverse
+	}`)
+	if cfg, _, err = parseConfig(c); err != nil || !cfg.NoReverse {
+		t.Fatalf("expected no_reverse, got err=%v cfg=%#v", err, cfg)
+	}
+}
+
+func TestParseConfigBackoff(t *testing.T) {
+	c := caddy.NewTestController("dns", `ztnet {
+		endpoint http://localhost:3000
+		token abc
+		network home.lan:abcdef01234567aa
+		backoff 1s 2m 0.5
+		max_stale 1h
+		unhealthy_after 10m
+	}`)
+	cfg, _, err := parseConfig(c)
+	if err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	if cfg.Backoff != (Backoff{Base: time.Second, Max: 2 * time.Minute, Jitter: 0.5}) {
+		t.Fatalf("unexpected backoff %#v", cfg.Backoff)
+	}
+	if cfg.MaxStale != time.Hour || cfg.UnhealthyAfter != 10*time.Minute {
+		t.Fatalf("unexpected max_stale %s or unhealthy_after %s", cfg.MaxStale, cfg.UnhealthyAfter)
+	}
+}
+
+func TestParseConfigWebhook(t *testing.T) {
+	t.Setenv("ZTNET_WEBHOOK_SECRET", "env-secret")
+	c := caddy.NewTestController("dns", `ztnet {
+		endpoint http://localhost:3000
+		token abc
+		network home.lan:abcdef01234567aa
+		webhook 127.0.0.1:9000
+	}`)
+	cfg, _, err := parseConfig(c)
+	if err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	if cfg.WebhookAddr != "127.0.0.1:9000" || cfg.WebhookSecret != "env-secret" {
+		t.Fatalf("unexpected webhook config %q %q", cfg.WebhookAddr, cfg.WebhookSecret)
+	}
+}
+
+func TestParseConfigControllerBackend(t *testing.T) {
+	t.Setenv("ZTNET_API_TOKEN", "")
+	c := caddy.NewTestController("dns", `ztnet {
+		backend controller
+		token secret
+		network home.lan:abcdef01234567aa
+	}`)
+	cfg, _, err := parseConfig(c)
+	if err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	if cfg.Backend != BackendController || cfg.APIAddress != DefaultControllerAddress || cfg.APIToken != "secret" {
+		t.Fatalf("unexpected cfg %#v", cfg)
+	}
+	if b, ok := newBackend(cfg).(*networkBackends).networks["abcdef01234567aa"].(*ControllerClient); !ok {
+		t.Fatalf("expected controller backend, got %T", b)
+	}
+}
+
+func TestParseConfigCentralNetwork(t *testing.T) {
+	t.Setenv("ZTNET_API_TOKEN", "")
+	t.Setenv("ZEROTIER_CENTRAL_TOKEN", "central-token")
+	c := caddy.NewTestController("dns", `ztnet {
+		endpoint http://localhost:3000
+		token abc
+		network home.lan:8056c2e21c000001
+		network cloud.lan:abcdef01234567aa central
+	}`)
+	cfg, _, err := parseConfig(c)
+	if err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	if cfg.Networks[0].Backend != BackendZTNET || cfg.Networks[1].Backend != BackendCentral {
+		t.Fatalf("unexpected networks %#v", cfg.Networks)
+	}
+	if cfg.CentralAddress != DefaultCentralAddress || cfg.CentralToken != "central-token" {
+		t.Fatalf("unexpected central config %q %q", cfg.CentralAddress, cfg.CentralToken)
+	}
+	backends := newBackend(cfg).(*networkBackends).networks
+	if _, ok := backends["8056c2e21c000001"].(*Client); !ok {
+		t.Fatalf("expected ZTNET backend, got %T", backends["8056c2e21c000001"])
+	}
+	if _, ok := backends["abcdef01234567aa"].(*CentralClient); !ok {
+		t.Fatalf("expected Central backend, got %T", backends["abcdef01234567aa"])
+	}
+
+	// Only Central networks, no ZTNET endpoint needed.
+	c = caddy.NewTestController("dns", `ztnet {
+		central_endpoint http://localhost:8080
+		central_token xyz
+		network cloud.lan:abcdef01234567aa central
+	}`)
+	if cfg, _, err = parseConfig(c); err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	if cfg.CentralAddress != "http://localhost:8080" || cfg.CentralToken != "xyz" {
+		t.Fatalf("unexpected central config %q %q", cfg.CentralAddress, cfg.CentralToken)
+	}
+}
+
+func TestParseConfigDiscover(t *testing.T) {
+	t.Setenv("ZTNET_API_TOKEN", "")
+	c := caddy.NewTestController("dns", `ztnet {
+		endpoint http://localhost:3000
+		token abc
+		org cm0abc
+		discover {{.NetworkName}}.zt.example.
+	}`)
+	cfg, _, err := parseConfig(c)
+	if err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	if cfg.Org != "cm0abc" || cfg.Discover == nil || len(cfg.Networks) != 0 {
+		t.Fatalf("unexpected cfg %#v", cfg)
+	}
+	zone, err := discoverZone(cfg.Discover, Network{ID: "8056c2e21c000001", Name: "lab"})
+	if err != nil || zone != "lab.zt.example." {
+		t.Fatalf("unexpected zone %q %v", zone, err)
+	}
+	if c, ok := newBackend(cfg).(*networkBackends).def.(*Client); !ok || c.org != "cm0abc" {
+		t.Fatalf("expected ZTNET backend scoped to the organization, got %#v", c)
+	}
+}
+
+func TestParseConfigOrgDefaultEndpoint(t *testing.T) {
+	c := caddy.NewTestController("dns", `ztnet {
+		endpoint http://localhost:3000
+		token abc
+		org cm0abc
+		network home.lan:8056c2e21c000001
+		network other.lan:abcdef01234567aa {
+			endpoint http://ztnet.example:3000
+		}
+	}`)
+	cfg, _, err := parseConfig(c)
+	if err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	backends := newBackend(cfg).(*networkBackends)
+	if c, ok := backends.networks["8056c2e21c000001"].(*Client); !ok || c.org != "cm0abc" {
+		t.Fatalf("expected the default endpoint scoped to the organization, got %#v", c)
+	}
+	if c, ok := backends.networks["abcdef01234567aa"].(*Client); !ok || c.org != "" {
+		t.Fatalf("did not expect another endpoint scoped to the organization, got %#v", c)
+	}
+}
+
+func TestParseConfigNames(t *testing.T) {
+	t.Setenv("ZTNET_API_TOKEN", "env-token")
+	c := caddy.NewTestController("dns", `ztnet {
+		endpoint http://localhost:3000
+		network home.lan:abcdef01234567aa
+	}`)
+	cfg, _, err := parseConfig(c)
+	if err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	if cfg.IDNA || cfg.Duplicates != DuplicateMerge {
+		t.Fatalf("unexpected defaults %v %q", cfg.IDNA, cfg.Duplicates)
+	}
+
+	c = caddy.NewTestController("dns", `ztnet {
+		endpoint http://localhost:3000
+		network home.lan:abcdef01234567aa
+		idna
+		duplicates suffix
+		wildcard
+	}`)
+	if cfg, _, err = parseConfig(c); err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	if !cfg.IDNA || cfg.Duplicates != DuplicateSuffix || !cfg.Wildcard {
+		t.Fatalf("unexpected name config %v %q", cfg.IDNA, cfg.Duplicates)
+	}
+}
+
+func TestParseConfigFilter(t *testing.T) {
+	t.Setenv("ZTNET_API_TOKEN", "env-token")
+	c := caddy.NewTestController("dns", `ztnet {
+		endpoint http://localhost:3000
+		network home.lan:abcdef01234567aa
+		online_within 720h
+		pending pending
+		exclude_tag 100=1 200
+		exclude_tag 300
+		exclude_name ^test-
+	}`)
+	cfg, _, err := parseConfig(c)
+	if err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	f := cfg.Filter
+	if f.OnlineWithin != 720*time.Hour || f.Pending != "pending" || len(f.ExcludeTags) != 3 || f.ExcludeName == nil || !f.ExcludeName.MatchString("test-vm") {
+		t.Fatalf("unexpected filter %#v", f)
+	}
+}
+
+func TestParseConfigGroups(t *testing.T) {
+	t.Setenv("ZTNET_API_TOKEN", "env-token")
+	c := caddy.NewTestController("dns", `ztnet {
+		endpoint http://localhost:3000
+		network home.lan:abcdef01234567aa
+		groups
+		group_tag web 100=1
+		group_tag web 200
+	}`)
+	cfg, _, err := parseConfig(c)
+	if err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	if cfg.Groups != DefaultGroupsLabel || !slices.Equal(cfg.GroupTags["web"], []string{"100=1", "200"}) {
+		t.Fatalf("unexpected groups %q %v", cfg.Groups, cfg.GroupTags)
+	}
+
+	c = caddy.NewTestController("dns", `ztnet {
+		endpoint http://localhost:3000
+		network home.lan:abcdef01234567aa
+		groups svc
+	}`)
+	if cfg, _, err = parseConfig(c); err != nil || cfg.Groups != "svc" {
+		t.Fatalf("unexpected groups label %q, error %v", cfg.Groups, err)
+	}
+}
+
+func TestParseConfigSnapshot(t *testing.T) {
+	t.Setenv("ZTNET_API_TOKEN", "env-token")
+	path := filepath.Join(t.TempDir(), "ztnet.json")
+	c := caddy.NewTestController("dns", `ztnet {
+		endpoint http://localhost:3000
+		network home.lan:abcdef01234567aa
+		snapshot `+path+` 72h
+	}`)
+	cfg, _, err := parseConfig(c)
+	if err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	if cfg.Snapshot != path || cfg.SnapshotMaxAge != 72*time.Hour {
+		t.Fatalf("unexpected snapshot %q with maximum age %s", cfg.Snapshot, cfg.SnapshotMaxAge)
+	}
+}
+
+func TestParseConfigNameTemplates(t *testing.T) {
+	t.Setenv("ZTNET_API_TOKEN", "env-token")
+	c := caddy.NewTestController("dns", `ztnet {
+		endpoint http://localhost:3000
+		network home.lan:abcdef01234567aa
+		network shared.lan:8056C2E21C000001
+		names {{.Name}} {{.ID}}.id
+		names 8056c2e21c000001 {{.Name}}-{{.NetworkShortID}}
+	}`)
+	cfg, _, err := parseConfig(c)
+	if err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	if len(cfg.Names) != 2 || cfg.Networks[0].Names != nil || len(cfg.Networks[1].Names) != 1 {
+		t.Fatalf("unexpected names %v %v", cfg.Names, cfg.Networks)
+	}
+}
+
+func TestParseConfigIPv6(t *testing.T) {
+	t.Setenv("ZTNET_API_TOKEN", "env-token")
+	c := caddy.NewTestController("dns", `ztnet {
+		endpoint http://localhost:3000
+		network home.lan:abcdef01234567aa
+		network shared.lan:8056c2e21c000001
+		network other.lan:1c33c1ced0000001
+		ipv6 assigned 6plane
+		ipv6 8056c2e21c000001 none
+	}`)
+	cfg, _, err := parseConfig(c)
+	if err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	if got := cfg.ipv6Sources(cfg.Networks[0]); got != (IPv6Sources{Assigned: true, SixPlane: true}) {
+		t.Fatalf("unexpected IPv6 sources %+v", got)
+	}
+	if got := cfg.ipv6Sources(cfg.Networks[1]); got != (IPv6Sources{}) {
+		t.Fatalf("unexpected IPv6 sources %+v", got)
+	}
+	if got := (&Config{}).ipv6Sources(cfg.Networks[2]); got != (IPv6Sources{Assigned: true, RFC4193: true, SixPlane: true}) {
+		t.Fatalf("unexpected default IPv6 sources %+v", got)
+	}
+}
+
+func TestParseConfigNetworkBlocks(t *testing.T) {
+	t.Setenv("ZTNET_API_TOKEN", "")
+	c := caddy.NewTestController("dns", `ztnet {
+		network home.lan 8056c2e21c000001 {
+			endpoint http://ztnet-a:3000
+			token token-a
+			ttl 10s
+			refresh 30s
+		}
+		network office.lan. ABCDEF01234567AA {
+			endpoint http://ztnet-b:3000
+			token token-b
+			names {{.Name}}
+			ipv6 none
+			members_only
 		}
+		network lab.lan 1c33c1ced0000001 {
+			endpoint http://ztnet-b:3000
+			token token-b
+		}
+		network cloud.lan 1c33c1ced0000002 central {
+			token central-token
+		}
+	}`)
+	cfg, _, err := parseConfig(c)
+	if err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	home, office := cfg.Networks[0], cfg.Networks[1]
+	if home.Zone != "home.lan." || home.APIAddress != "http://ztnet-a:3000" || home.APIToken != "token-a" || home.DNSTTL != 10*time.Second || home.RefreshTTL != 30*time.Second {
+		t.Fatalf("unexpected network %#v", home)
+	}
+	if office.Zone != "office.lan." || office.NetworkID != "abcdef01234567aa" || len(office.Names) != 1 || office.IPv6 == nil || !office.MembersOnly || home.MembersOnly {
+		t.Fatalf("unexpected network %#v", office)
+	}
+	if cfg.dnsTTL("home.lan.") != 10*time.Second || cfg.dnsTTL("office.lan.") != DefaultDNSTTL {
+		t.Fatalf("unexpected TTLs %v %v", cfg.dnsTTL("home.lan."), cfg.dnsTTL("office.lan."))
+	}
+	if cfg.refreshTTL(home) != 30*time.Second || cfg.refreshTTL(office) != DefaultRefreshTTL {
+		t.Fatalf("unexpected refresh intervals %v %v", cfg.refreshTTL(home), cfg.refreshTTL(office))
+	}
+	if cloud := cfg.Networks[3]; cloud.APIAddress != DefaultCentralAddress || cloud.APIToken != "central-token" {
+		t.Fatalf("unexpected network %#v", cloud)
+	}
+
+	backends := newBackend(cfg).(*networkBackends).networks
+	a, b := backends["8056c2e21c000001"].(*Client), backends["abcdef01234567aa"].(*Client)
+	if a.baseURL != "http://ztnet-a:3000" || a.token.token != "token-a" || b.baseURL != "http://ztnet-b:3000" || b.token.token != "token-b" {
+		t.Fatalf("unexpected clients %#v %#v", a, b)
+	}
+	if backends["1c33c1ced0000001"] != Backend(b) {
+		t.Fatal("expected networks with the same endpoint and token to share a client")
+	}
+	if _, ok := backends["1c33c1ced0000002"].(*CentralClient); !ok {
+		t.Fatalf("expected Central backend, got %T", backends["1c33c1ced0000002"])
+	}
+}
+
+func TestParseConfigTokenFile(t *testing.T) {
+	t.Setenv("ZTNET_API_TOKEN", "")
+	dir := t.TempDir()
+	for _, name := range []string{"ztnet", "lab", "central"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name+"-token\n"), 0o600); err != nil {
+			t.Fatalf("write token file: %v", err)
+		}
+	}
+	c := caddy.NewTestController("dns", fmt.Sprintf(`ztnet {
+		endpoint http://localhost:3000
+		token_file %[1]s/ztnet
+		central_token_file %[1]s/central
+		network home.lan 8056c2e21c000001
+		network lab.lan abcdef01234567aa {
+			token_file %[1]s/lab
+		}
+		network cloud.lan 1c33c1ced0000001 central
+	}`, dir))
+	cfg, _, err := parseConfig(c)
+	if err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	if cfg.APIToken != "" || cfg.Networks[0].APITokenFile != dir+"/ztnet" || cfg.Networks[1].APITokenFile != dir+"/lab" || cfg.Networks[2].APITokenFile != dir+"/central" {
+		t.Fatalf("unexpected token files %#v", cfg.Networks)
+	}
+
+	backends := newBackend(cfg).(*networkBackends).networks
+	for id, want := range map[string]string{"8056c2e21c000001": "ztnet-token", "abcdef01234567aa": "lab-token"} {
+		if token, err := backends[id].(*Client).token.get(false); err != nil || token != want {
+			t.Fatalf("network %s: want token %q, got %q %v", id, want, token, err)
+		}
+	}
+	if token, err := backends["1c33c1ced0000001"].(*CentralClient).token.get(false); err != nil || token != "central-token" {
+		t.Fatalf("want central token, got %q %v", token, err)
+	}
+}
+
+// writeClientCert writes a self-signed client certificate and its key to dir and returns their paths
+// and the certificate.
+func writeClientCert(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "coredns"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	certPath, keyPath := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	writePEM(t, certPath, "CERTIFICATE", der)
+	writePEM(t, keyPath, "EC PRIVATE KEY", keyDER)
+	return certPath, keyPath, cert
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func TestParseConfigTLS(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath, clientCert := writeClientCert(t, dir)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write([]byte(`[]`)); err != nil {
+			t.Errorf("write response: %v", err)
+		}
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	ts.StartTLS()
+	defer ts.Close()
+	caPath := filepath.Join(dir, "ca.pem")
+	writePEM(t, caPath, "CERTIFICATE", ts.Certificate().Raw)
+
+	// The test server certificate is valid for example.com and 127.0.0.1.
+	for serverName, ok := range map[string]bool{"example.com": true, "ztnet.example.org": false} {
+		c := caddy.NewTestController("dns", fmt.Sprintf(`ztnet {
+			endpoint %s
+			token t
+			network home.lan 8056c2e21c000001
+			tls %s %s %s
+			tls_servername %s
+			timeout 5s
+		}`, ts.URL, certPath, keyPath, caPath, serverName))
+		cfg, _, err := parseConfig(c)
+		if err != nil {
+			t.Fatalf("parseConfig error: %v", err)
+		}
+		client := newBackend(cfg).(*networkBackends).networks["8056c2e21c000001"].(*Client)
+		if client.httpClient.Timeout != 5*time.Second {
+			t.Fatalf("unexpected timeout %s", client.httpClient.Timeout)
+		}
+		_, err = client.GetMembers(context.Background(), "8056c2e21c000001")
+		if ok && err != nil {
+			t.Fatalf("%s: GetMembers error: %v", serverName, err)
+		}
+		if !ok && err == nil {
+			t.Fatalf("%s: expected certificate verification error", serverName)
+		}
+	}
+
+	// Without the client certificate the server rejects the connection.
+	c := caddy.NewTestController("dns", fmt.Sprintf(`ztnet {
+		endpoint %s
+		token t
+		network home.lan 8056c2e21c000001
+		tls %s
+	}`, ts.URL, caPath))
+	cfg, _, err := parseConfig(c)
+	if err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	if _, err := newBackend(cfg).GetMembers(context.Background(), "8056c2e21c000001"); err == nil {
+		t.Fatal("expected error without client certificate")
+	}
+}
+
+func TestParseConfigProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		if _, err := w.Write([]byte(`[]`)); err != nil {
+			t.Errorf("write response: %v", err)
+		}
+	}))
+	defer proxy.Close()
+
+	c := caddy.NewTestController("dns", fmt.Sprintf(`ztnet {
+		endpoint http://ztnet.internal:3000
+		token t
+		network home.lan 8056c2e21c000001
+		network cloud.lan 1c33c1ced0000001 central
+		central_endpoint http://central.internal
+		central_token t
+		proxy %s
+	}`, proxy.URL))
+	cfg, _, err := parseConfig(c)
+	if err != nil {
+		t.Fatalf("parseConfig error: %v", err)
+	}
+	b := newBackend(cfg)
+	for _, id := range []string{"8056c2e21c000001", "1c33c1ced0000001"} {
+		if _, err := b.GetMembers(context.Background(), id); err != nil {
+			t.Fatalf("GetMembers error: %v", err)
+		}
+	}
+	want := []string{"http://ztnet.internal:3000/api/v1/network/8056c2e21c000001/member/", "http://central.internal/api/v1/network/1c33c1ced0000001/member"}
+	if len(proxied) != 2 || proxied[0] != want[0] || proxied[1] != want[1] {
+		t.Fatalf("expected requests through the proxy, got %v", proxied)
 	}
 }
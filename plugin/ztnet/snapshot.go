@@ -0,0 +1,173 @@
+package ztnet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// snapshotVersion is the version of the snapshot format, snapshots of another version are ignored.
+const snapshotVersion = 1
+
+// snapshot is the last-good data of all networks as written to disk.
+type snapshot struct {
+	Version int       `json:"version"`
+	Written time.Time `json:"written"`
+	// Discovered is the time of the last successful discovery, zero without discovery.
+	Discovered time.Time         `json:"discovered"`
+	Networks   []snapshotNetwork `json:"networks"`
+	// DiscoverSynced is the time of the last discovery from the API by this process, it is only handed
+	// over on a reload and not written to the file.
+	DiscoverSynced time.Time `json:"-"`
+}
+
+// snapshotNetwork is the last-good data of a single network. Zone is only set for discovered networks,
+// configured networks get their zone from the Corefile.
+type snapshotNetwork struct {
+	ID      string       `json:"id"`
+	Zone    string       `json:"zone,omitempty"`
+	Updated time.Time    `json:"updated"`
+	Info    *NetworkInfo `json:"info"`
+	Members []Member     `json:"members"`
+	// Synced is the time of the last refresh from the API by this process, like Updated it is only handed
+	// over on a reload and not written to the file, so data from a file does not count for readiness.
+	Synced time.Time `json:"-"`
+}
+
+// snapshot returns the last-good data of networks. It must only be called from refresh, the snapshot
+// shares the network data, which is never modified in place.
+func (rc *RecordCache) snapshot(cfg *Config, networks []NetworkZone, now time.Time) *snapshot {
+	s := &snapshot{Version: snapshotVersion, Written: now, Discovered: rc.discovered, DiscoverSynced: rc.discoverSynced}
+	for _, nz := range networks {
+		st := rc.networks[nz.NetworkID]
+		if st == nil || st.info == nil {
+			continue
+		}
+		sn := snapshotNetwork{ID: nz.NetworkID, Updated: st.updated, Synced: st.synced, Info: st.info, Members: st.members}
+		if !cfg.configured(nz.NetworkID) {
+			sn.Zone = nz.Zone
+		}
+		s.Networks = append(s.Networks, sn)
+	}
+	return s
+}
+
+// lastGood returns the snapshot of the last refresh, nil before the first one.
+func (rc *RecordCache) lastGood() *snapshot {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return rc.last
+}
+
+// writeSnapshot writes s to path. The file is replaced atomically, so a crash never leaves a partial
+// snapshot behind.
+func writeSnapshot(path string, s *snapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("ztnet: snapshot: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("ztnet: snapshot: %w", err)
+	}
+	defer os.Remove(f.Name()) // fails once the file is renamed
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("ztnet: snapshot: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("ztnet: snapshot: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("ztnet: snapshot: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("ztnet: snapshot: %w", err)
+	}
+	return nil
+}
+
+// loadSnapshot restores the last-good data of the networks from the snapshot at cfg.Snapshot, see
+// restore. A missing snapshot is not an error, snapshots of another version or older than
+// cfg.SnapshotMaxAge are ignored. It must be called before the refresh loop starts.
+func (rc *RecordCache) loadSnapshot(cfg *Config, now time.Time) error {
+	data, err := os.ReadFile(cfg.Snapshot)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ztnet: snapshot: %w", err)
+	}
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("ztnet: snapshot: %s: %w", cfg.Snapshot, err)
+	}
+	if s.Version != snapshotVersion {
+		return fmt.Errorf("ztnet: snapshot: %s has version %d, want %d", cfg.Snapshot, s.Version, snapshotVersion)
+	}
+	if cfg.SnapshotMaxAge > 0 && now.Sub(s.Written) > cfg.SnapshotMaxAge {
+		return fmt.Errorf("ztnet: snapshot: %s is from %s, older than %s", cfg.Snapshot, s.Written.Format(time.RFC3339), cfg.SnapshotMaxAge)
+	}
+	n := rc.restore(cfg, &s, now)
+	log.Infof("Loaded %d networks from snapshot %s written at %s", n, cfg.Snapshot, s.Written.Format(time.RFC3339))
+	return nil
+}
+
+// restore takes over the network data of s that is newer than the data the cache has, and builds the
+// records from it, so they are served until the first refresh. The networks are refreshed when their
+// data is due, like data fetched by the cache itself, but only data a running poller fetched from the API
+// counts as synced for readiness. Networks that are not configured, or whose data is older than
+// max_stale, are skipped. It returns the number of networks taken over and must be called
+// before the refresh loop starts.
+func (rc *RecordCache) restore(cfg *Config, s *snapshot, now time.Time) int {
+	n := 0
+	for _, sn := range s.Networks {
+		if sn.Info == nil || (cfg.MaxStale > 0 && now.Sub(sn.Updated) > cfg.MaxStale) {
+			continue
+		}
+		nz := NetworkZone{Zone: sn.Zone, NetworkID: sn.ID, Backend: cfg.Backend}
+		if sn.Zone != "" {
+			// A discovered network, it is only restored with discovery and when not configured explicitly.
+			if cfg.Discover == nil || cfg.configured(sn.ID) {
+				continue
+			}
+			if !slices.ContainsFunc(rc.discoveredNetworks, func(nz NetworkZone) bool { return nz.NetworkID == sn.ID }) {
+				rc.discoveredNetworks = append(rc.discoveredNetworks, nz)
+			}
+		} else {
+			i := slices.IndexFunc(cfg.Networks, func(nz NetworkZone) bool { return nz.NetworkID == sn.ID })
+			if i < 0 {
+				continue
+			}
+			nz = cfg.Networks[i]
+		}
+		st := rc.state(cfg, sn.ID)
+		// The running pollers hand over the data the snapshot file has too, only with the time of their sync.
+		if sn.Synced.After(st.synced) {
+			st.synced = sn.Synced
+		}
+		if st.info != nil && !st.updated.Before(sn.Updated) {
+			continue
+		}
+		st.info, st.members, st.updated, st.next = sn.Info, sn.Members, sn.Updated, sn.Updated.Add(cfg.refreshTTL(nz))
+		n++
+	}
+	if cfg.Discover != nil && rc.discovered.Before(s.Discovered) {
+		rc.discovered = s.Discovered
+		rc.discoverNext = s.Discovered.Add(cfg.RefreshTTL)
+	}
+	if cfg.Discover != nil && rc.discoverSynced.Before(s.DiscoverSynced) {
+		rc.discoverSynced = s.DiscoverSynced
+	}
+
+	networks := slices.Concat(cfg.Networks, rc.discoveredNetworks)
+	rc.Replace(rc.build(cfg, networks, now))
+	rc.updateLastSync(cfg, networks)
+	return n
+}
@@ -0,0 +1,170 @@
+package ztnet
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSnapshotColdStart(t *testing.T) {
+	var down atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body := `{"v6AssignMode":{"6plane":false,"rfc4193":true},"routes":[{"target":"10.147.17.0/24","via":null}]}`
+		if r.URL.Path == "/api/v1/network/8056c2e21c000001/member/" {
+			body = `[{"id":"efcc1b0947","name":"node","authorized":true,"ipAssignments":["10.147.17.2"]}]`
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Errorf("write response: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "ztnet.json")
+	cfg := &Config{Networks: []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}}, Snapshot: path}
+	c := NewClient(ts.URL, "token")
+	if err := (&RecordCache{}).refresh(context.Background(), c, cfg); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected snapshot to be written: %v", err)
+	}
+
+	// A restart while the API is down serves the records of the snapshot.
+	down.Store(true)
+	rc := &RecordCache{}
+	if err := rc.loadSnapshot(cfg, time.Now()); err != nil {
+		t.Fatalf("loadSnapshot error: %v", err)
+	}
+	if _, ok := rc.Lookup("node.home.lan."); !ok {
+		t.Fatal("expected record from snapshot")
+	}
+	if _, ok := rc.LookupPTR("2.17.147.10.in-addr.arpa."); !ok {
+		t.Fatal("expected PTR record from snapshot")
+	}
+	if zones := rc.ReverseZones(); len(zones) != 2 || zones[0] != "17.147.10.in-addr.arpa." {
+		t.Fatalf("unexpected reverse zones from snapshot %v", zones)
+	}
+	if !rc.LastSync().IsZero() {
+		t.Fatal("did not expect the snapshot to count as sync")
+	}
+	if err := rc.refresh(context.Background(), c, cfg); err == nil {
+		t.Fatal("expected refresh error")
+	}
+	if _, ok := rc.Lookup("node.home.lan."); !ok {
+		t.Fatal("expected stale record from snapshot after failed refresh")
+	}
+
+	down.Store(false)
+	if err := rc.refresh(context.Background(), c, cfg); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+	if rc.LastSync().IsZero() {
+		t.Fatal("expected sync once the API answers")
+	}
+}
+
+func TestSnapshotIgnored(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	write := func(s snapshot) string {
+		data, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "ztnet.json")
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	network := snapshotNetwork{ID: "8056c2e21c000001", Updated: now.Add(-time.Hour), Info: &NetworkInfo{}, Members: []Member{{ID: "efcc1b0947", Name: "node", Authorized: true}}}
+	networks := []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}}
+
+	cfg := &Config{Networks: networks, Snapshot: filepath.Join(dir, "missing.json")}
+	if err := (&RecordCache{}).loadSnapshot(cfg, now); err != nil {
+		t.Fatalf("expected no error for missing snapshot, got %v", err)
+	}
+
+	cfg.Snapshot = write(snapshot{Version: snapshotVersion + 1, Written: now, Networks: []snapshotNetwork{network}})
+	if err := (&RecordCache{}).loadSnapshot(cfg, now); err == nil {
+		t.Fatal("expected error for snapshot of another version")
+	}
+
+	cfg.Snapshot = write(snapshot{Version: snapshotVersion, Written: now.Add(-48 * time.Hour), Networks: []snapshotNetwork{network}})
+	cfg.SnapshotMaxAge = 24 * time.Hour
+	if err := (&RecordCache{}).loadSnapshot(cfg, now); err == nil {
+		t.Fatal("expected error for old snapshot")
+	}
+	cfg.SnapshotMaxAge = 0
+
+	cfg.MaxStale = time.Minute
+	rc := &RecordCache{}
+	if err := rc.loadSnapshot(cfg, now); err != nil {
+		t.Fatalf("loadSnapshot error: %v", err)
+	}
+	if _, ok := rc.Lookup("node.home.lan."); ok {
+		t.Fatal("did not expect network older than max_stale")
+	}
+	cfg.MaxStale = 0
+
+	// Networks that are no longer configured are skipped.
+	cfg.Networks = []NetworkZone{{Zone: "work.lan.", NetworkID: "abcdef01234567aa"}}
+	rc = &RecordCache{}
+	if err := rc.loadSnapshot(cfg, now); err != nil {
+		t.Fatalf("loadSnapshot error: %v", err)
+	}
+	if _, ok := rc.networks["8056c2e21c000001"]; ok {
+		t.Fatal("did not expect unconfigured network")
+	}
+	if !rc.LastSync().IsZero() {
+		t.Fatal("did not expect sync without data for every network")
+	}
+}
+
+func TestSnapshotDiscovered(t *testing.T) {
+	tmpl, err := parseZoneTemplate("{{.NetworkName}}.zt.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	data, err := json.Marshal(snapshot{Version: snapshotVersion, Written: now, Discovered: now, Networks: []snapshotNetwork{
+		{ID: "8056c2e21c000001", Zone: "lab.zt.example.", Updated: now, Info: &NetworkInfo{}, Members: []Member{{ID: "efcc1b0947", Name: "node", Authorized: true}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "ztnet.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rc := &RecordCache{}
+	if err := rc.loadSnapshot(&Config{Discover: tmpl, Snapshot: path}, now); err != nil {
+		t.Fatalf("loadSnapshot error: %v", err)
+	}
+	if _, ok := rc.Lookup("node.lab.zt.example."); !ok {
+		t.Fatal("expected record of discovered network")
+	}
+	if len(rc.discoveredNetworks) != 1 || !rc.LastSync().IsZero() {
+		t.Fatalf("unexpected discovered networks %v", rc.discoveredNetworks)
+	}
+
+	// Without discovery, discovered networks are not restored.
+	rc = &RecordCache{}
+	if err := rc.loadSnapshot(&Config{Snapshot: path}, now); err != nil {
+		t.Fatalf("loadSnapshot error: %v", err)
+	}
+	if _, ok := rc.Lookup("node.lab.zt.example."); ok {
+		t.Fatal("did not expect record of discovered network without discovery")
+	}
+}
@@ -0,0 +1,102 @@
+package ztnet
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errTokenRejected is returned by getJSON when the API answers 401 or 403.
+var errTokenRejected = errors.New("API token rejected")
+
+// tokenSource provides the API token of a client, either a fixed one or the content of a file. The file
+// is read again when it changes, so a token can be rotated without a reload. A tokenSource never prints
+// the token.
+type tokenSource struct {
+	path string
+
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+}
+
+// staticToken returns a tokenSource for a fixed token.
+func staticToken(token string) *tokenSource { return &tokenSource{token: token} }
+
+// fileToken returns a tokenSource that reads the token from path.
+func fileToken(path string) *tokenSource { return &tokenSource{path: path} }
+
+// get returns the current token, reading the token file when it changed since the last read or when
+// force is set.
+func (ts *tokenSource) get(force bool) (string, error) {
+	if ts.path == "" {
+		return ts.token, nil
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	fi, err := os.Stat(ts.path)
+	if err != nil {
+		return "", fmt.Errorf("token file: %w", err)
+	}
+	if !force && ts.token != "" && fi.ModTime().Equal(ts.modTime) && fi.Size() == ts.size {
+		return ts.token, nil
+	}
+	token, err := readTokenFile(ts.path)
+	if err != nil {
+		return "", fmt.Errorf("token file: %w", err)
+	}
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", ts.path)
+	}
+	if ts.token != "" && token != ts.token {
+		log.Infof("API token in %s changed", ts.path)
+	}
+	ts.token, ts.modTime, ts.size = token, fi.ModTime(), fi.Size()
+	return token, nil
+}
+
+// do calls fn with the current token. When the API rejects it, the token file is read again right
+// away and fn is retried once if the token was rotated.
+func (ts *tokenSource) do(fn func(token string) error) error {
+	token, err := ts.get(false)
+	if err != nil {
+		return err
+	}
+	err = fn(token)
+	if !errors.Is(err, errTokenRejected) || ts.path == "" {
+		return err
+	}
+	fresh, ferr := ts.get(true)
+	if ferr != nil {
+		return errors.Join(err, ferr)
+	}
+	if fresh == token {
+		return fmt.Errorf("%w, check the token in %s", err, ts.path)
+	}
+	return fn(fresh)
+}
+
+// String implements fmt.Stringer, so the token does not end up in logs or config dumps.
+func (ts *tokenSource) String() string {
+	if ts.path != "" {
+		return "token from " + ts.path
+	}
+	return "token"
+}
+
+// GoString implements fmt.GoStringer, like String.
+func (ts *tokenSource) GoString() string { return ts.String() }
+
+// readTokenFile returns the trimmed content of a token file, like authtoken.secret.
+func readTokenFile(path string) (string, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(buf)), nil
+}
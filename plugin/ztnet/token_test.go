@@ -0,0 +1,95 @@
+package ztnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeToken(t *testing.T, path, token string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(token+"\n"), 0o600); err != nil {
+		t.Fatalf("write token file: %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("set token file time: %v", err)
+	}
+}
+
+func TestTokenSourceRereadsChangedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	start := time.Now().Add(-time.Hour)
+	writeToken(t, path, "first", start)
+
+	ts := fileToken(path)
+	if token, err := ts.get(false); err != nil || token != "first" {
+		t.Fatalf("want first, got %q %v", token, err)
+	}
+	writeToken(t, path, "second", start.Add(time.Minute))
+	if token, err := ts.get(false); err != nil || token != "second" {
+		t.Fatalf("want second, got %q %v", token, err)
+	}
+
+	writeToken(t, path, "", start.Add(2*time.Minute))
+	if _, err := ts.get(false); err == nil {
+		t.Fatal("expected error for empty token file")
+	}
+}
+
+func TestTokenSourceRetriesRotatedToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	modTime := time.Now().Add(-time.Hour)
+	writeToken(t, path, "old", modTime)
+
+	var seen []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		seen = append(seen, auth)
+		if auth != "Bearer new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if _, err := w.Write([]byte(`[]`)); err != nil {
+			t.Fatalf("write response: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	c := NewClient(ts.URL, "")
+	c.token = fileToken(path)
+	_, err := c.GetMembers(context.Background(), "8056c2e21c000001")
+	if !errors.Is(err, errTokenRejected) || !strings.Contains(err.Error(), path) {
+		t.Fatalf("expected rejected token error naming the file, got %v", err)
+	}
+	if strings.Contains(err.Error(), "Bearer") {
+		t.Fatalf("token leaked in error %q", err)
+	}
+
+	// Rotated without a visible change of the file, the rejection makes it read again.
+	writeToken(t, path, "new", modTime)
+	seen = nil
+	if _, err := c.GetMembers(context.Background(), "8056c2e21c000001"); err != nil {
+		t.Fatalf("GetMembers error: %v", err)
+	}
+	if len(seen) != 2 || seen[0] != "Bearer old" || seen[1] != "Bearer new" {
+		t.Fatalf("expected a retry with the new token, got %v", seen)
+	}
+}
+
+func TestTokenSourceNotPrinted(t *testing.T) {
+	for _, ts := range []*tokenSource{staticToken("s3cret"), {path: "/run/secrets/ztnet", token: "s3cret"}} {
+		c := &Client{token: ts}
+		for _, out := range []string{fmt.Sprintf("%v", c), fmt.Sprintf("%+v", c), fmt.Sprintf("%#v", c)} {
+			if strings.Contains(out, "s3cret") {
+				t.Fatalf("token leaked in %q", out)
+			}
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package ztnet
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// webhookPath is the HTTP path ZTNET webhook events are posted to.
+	webhookPath = "/webhook"
+	// webhookMaxBody limits the size of a webhook request body.
+	webhookMaxBody = 1 << 20
+	// webhookSignatureHeader carries the hex encoded HMAC-SHA256 of the request body, prefixed with "sha256=".
+	webhookSignatureHeader = "X-Ztnet-Signature"
+
+	shutdownTimeout = 5 * time.Second
+)
+
+// webhookEvent is the part of a ZTNET webhook event the plugin uses. Every event type about a member
+// (added, authorized, deauthorized, renamed, IP changed, deleted) carries the network it belongs to.
+type webhookEvent struct {
+	HookType  string `json:"hookType"`
+	NetworkID string `json:"networkId"`
+	MemberID  string `json:"memberId"`
+}
+
+// webhook receives ZTNET webhook events and triggers an immediate refresh of the network an event is
+// about. Polling keeps running as a safety net for missed events.
+type webhook struct {
+	addr     string
+	secret   string
+	networks map[string]struct{}
+	// discover accepts events for any network, as it may have been created since the last discovery.
+	discover bool
+	trigger  func(networkID string, at time.Time)
+}
+
+func newWebhook(cfg *Config, trigger func(networkID string, at time.Time)) *webhook {
+	wh := &webhook{addr: cfg.WebhookAddr, secret: cfg.WebhookSecret, networks: make(map[string]struct{}), discover: cfg.Discover != nil, trigger: trigger}
+	for _, nz := range cfg.Networks {
+		wh.networks[nz.NetworkID] = struct{}{}
+	}
+	return wh
+}
+
+// OnStartup starts receiving the events posted to the address of wh.
+func (wh *webhook) OnStartup() error { return webhookListeners.add(wh) }
+
+// OnShutdown stops receiving events.
+func (wh *webhook) OnShutdown() error {
+	webhookListeners.remove(wh)
+	return nil
+}
+
+// handle authenticates the event in body, received at at, and triggers a refresh of its network. It
+// returns the HTTP status of the outcome.
+func (wh *webhook) handle(r *http.Request, body []byte, at time.Time) int {
+	if !wh.authorized(r, body) {
+		return http.StatusUnauthorized
+	}
+	var event webhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return http.StatusBadRequest
+	}
+	networkID := strings.ToLower(event.NetworkID)
+	if _, ok := wh.networks[networkID]; !ok && (!wh.discover || networkID == "") {
+		log.Debugf("Ignoring webhook event %q for unknown network %q", event.HookType, event.NetworkID)
+		return http.StatusNoContent
+	}
+
+	log.Debugf("Webhook event %q for member %q of network %s, refreshing", event.HookType, event.MemberID, networkID)
+	wh.trigger(networkID, at)
+	return http.StatusAccepted
+}
+
+// webhookStatus orders the outcomes of handle, an event passed to several receivers gets the status
+// of the receiver that came furthest.
+var webhookStatus = []int{http.StatusUnauthorized, http.StatusBadRequest, http.StatusNoContent, http.StatusAccepted}
+
+// serveWebhook passes the event of r to each receiver in whs.
+func serveWebhook(w http.ResponseWriter, r *http.Request, whs []*webhook) {
+	at := time.Now()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, webhookMaxBody))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusUnauthorized
+	for _, wh := range whs {
+		if s := wh.handle(r, body, at); slices.Index(webhookStatus, s) > slices.Index(webhookStatus, status) {
+			status = s
+		}
+	}
+	if status == http.StatusUnauthorized {
+		log.Warningf("Rejected unauthenticated webhook request from %s", r.RemoteAddr)
+	}
+	w.WriteHeader(status)
+}
+
+// webhookListeners holds the webhook listeners of the process. Pollers with the same webhook address,
+// of one Corefile instance or of the previous one during a reload, share the listener of that address
+// and each of them gets every event.
+var webhookListeners = &webhookRegistry{listeners: make(map[string]*webhookListener)}
+
+type webhookRegistry struct {
+	mu        sync.Mutex
+	listeners map[string]*webhookListener
+}
+
+// webhookListener serves the webhook endpoint on one address for the receivers registered with it.
+type webhookListener struct {
+	mu       sync.RWMutex
+	webhooks []*webhook
+
+	ln  net.Listener
+	srv *http.Server
+}
+
+// add registers wh with the listener of its address, and starts listening when wh is the first.
+func (r *webhookRegistry) add(wh *webhook) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if l, ok := r.listeners[wh.addr]; ok {
+		l.mu.Lock()
+		l.webhooks = append(l.webhooks, wh)
+		l.mu.Unlock()
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", wh.addr)
+	if err != nil {
+		return err
+	}
+	l := &webhookListener{webhooks: []*webhook{wh}, ln: ln}
+	mux := http.NewServeMux()
+	mux.Handle(webhookPath, l)
+	l.srv = &http.Server{
+		Handler:      mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		IdleTimeout:  5 * time.Second,
+	}
+	go func() { l.srv.Serve(l.ln) }()
+	r.listeners[wh.addr] = l
+	return nil
+}
+
+// remove unregisters wh, and stops the listener of its address when wh was the last.
+func (r *webhookRegistry) remove(wh *webhook) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.listeners[wh.addr]
+	if !ok {
+		return
+	}
+	l.mu.Lock()
+	l.webhooks = slices.DeleteFunc(l.webhooks, func(other *webhook) bool { return other == wh })
+	n := len(l.webhooks)
+	l.mu.Unlock()
+	if n > 0 {
+		return
+	}
+
+	delete(r.listeners, wh.addr)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := l.srv.Shutdown(ctx); err != nil {
+		log.Infof("Failed to stop webhook listener: %s", err)
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (l *webhookListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.mu.RLock()
+	whs := slices.Clone(l.webhooks)
+	l.mu.RUnlock()
+	serveWebhook(w, r, whs)
+}
+
+// authorized checks the request carries the shared secret as bearer token, or a valid HMAC-SHA256
+// signature of the body made with the shared secret.
+func (wh *webhook) authorized(r *http.Request, body []byte) bool {
+	if sig, ok := strings.CutPrefix(r.Header.Get(webhookSignatureHeader), "sha256="); ok {
+		got, err := hex.DecodeString(sig)
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha256.New, []byte(wh.secret))
+		mac.Write(body)
+		return hmac.Equal(got, mac.Sum(nil))
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(wh.secret)) == 1
+	}
+	return false
+}
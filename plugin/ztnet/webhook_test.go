@@ -0,0 +1,182 @@
+package ztnet
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testEvent = `{"hookType":"MEMBER_CONFIG_CHANGED","networkId":"8056c2e21c000001","memberId":"efcc1b0947"}`
+
+func newTestWebhook(trigger func(string, time.Time)) *webhook {
+	cfg := &Config{
+		Networks:      []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}},
+		WebhookSecret: "s3cret",
+	}
+	return newWebhook(cfg, trigger)
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookAuthentication(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   int
+	}{
+		{"bearer", "Authorization", "Bearer s3cret", http.StatusAccepted},
+		{"hmac", webhookSignatureHeader, sign("s3cret", testEvent), http.StatusAccepted},
+		{"wrong bearer", "Authorization", "Bearer wrong", http.StatusUnauthorized},
+		{"wrong hmac", webhookSignatureHeader, sign("wrong", testEvent), http.StatusUnauthorized},
+		{"malformed hmac", webhookSignatureHeader, "sha256=zz", http.StatusUnauthorized},
+		{"none", "X-Other", "", http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		var triggered []string
+		wh := newTestWebhook(func(networkID string, _ time.Time) { triggered = append(triggered, networkID) })
+
+		req := httptest.NewRequest(http.MethodPost, webhookPath, strings.NewReader(testEvent))
+		req.Header.Set(tc.header, tc.value)
+		rec := httptest.NewRecorder()
+		serveWebhook(rec, req, []*webhook{wh})
+
+		if rec.Code != tc.want {
+			t.Fatalf("%s: want status %d, got %d", tc.name, tc.want, rec.Code)
+		}
+		if tc.want == http.StatusAccepted && (len(triggered) != 1 || triggered[0] != "8056c2e21c000001") {
+			t.Fatalf("%s: expected refresh of 8056c2e21c000001, got %v", tc.name, triggered)
+		}
+		if tc.want != http.StatusAccepted && len(triggered) != 0 {
+			t.Fatalf("%s: did not expect a refresh, got %v", tc.name, triggered)
+		}
+	}
+}
+
+func TestWebhookRejectsBadRequests(t *testing.T) {
+	wh := newTestWebhook(func(networkID string, _ time.Time) { t.Fatalf("did not expect refresh of %s", networkID) })
+
+	tests := []struct {
+		method string
+		body   string
+		want   int
+	}{
+		{http.MethodGet, "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "not json", http.StatusBadRequest},
+		{http.MethodPost, `{"hookType":"MEMBER_DELETED","networkId":"abcdef01234567aa"}`, http.StatusNoContent},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, webhookPath, strings.NewReader(tc.body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		serveWebhook(rec, req, []*webhook{wh})
+		if rec.Code != tc.want {
+			t.Fatalf("%s %q: want status %d, got %d", tc.method, tc.body, tc.want, rec.Code)
+		}
+	}
+}
+
+func TestWebhookDiscover(t *testing.T) {
+	var triggered []string
+	wh := newTestWebhook(func(networkID string, _ time.Time) { triggered = append(triggered, networkID) })
+	wh.discover = true
+
+	req := httptest.NewRequest(http.MethodPost, webhookPath, strings.NewReader(`{"hookType":"NETWORK_CREATED","networkId":"abcdef01234567aa"}`))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	serveWebhook(rec, req, []*webhook{wh})
+	if rec.Code != http.StatusAccepted || len(triggered) != 1 || triggered[0] != "abcdef01234567aa" {
+		t.Fatalf("expected refresh of unknown network with discovery, got status %d %v", rec.Code, triggered)
+	}
+}
+
+func TestWebhookTriggersRefresh(t *testing.T) {
+	var memberCalls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := `{}`
+		if strings.HasSuffix(r.URL.Path, "/member/") {
+			memberCalls.Add(1)
+			body = `[{"id":"efcc1b0947","name":"node","authorized":true,"ipAssignments":["10.0.0.2"]}]`
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatalf("write response: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := &Config{Networks: []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}}, RefreshTTL: time.Hour}
+	rc := &RecordCache{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go rc.refreshLoop(ctx, NewClient(ts.URL, "token"), cfg)
+
+	waitFor := func(n int32) {
+		deadline := time.Now().Add(5 * time.Second)
+		for memberCalls.Load() < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %d member requests, got %d", n, memberCalls.Load())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor(1)
+
+	wh := newTestWebhook(rc.Trigger)
+	req := httptest.NewRequest(http.MethodPost, webhookPath, strings.NewReader(testEvent))
+	req.Header.Set(webhookSignatureHeader, sign("s3cret", testEvent))
+	serveWebhook(httptest.NewRecorder(), req, []*webhook{wh})
+	waitFor(2)
+}
+
+func TestWebhookSharedListener(t *testing.T) {
+	var a, b atomic.Int32
+	cfg := &Config{
+		Networks:      []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}},
+		WebhookAddr:   "127.0.0.1:0",
+		WebhookSecret: "s3cret",
+	}
+	whA := newWebhook(cfg, func(string, time.Time) { a.Add(1) })
+	whB := newWebhook(cfg, func(string, time.Time) { b.Add(1) })
+	if err := whA.OnStartup(); err != nil {
+		t.Fatalf("OnStartup error: %v", err)
+	}
+	defer whA.OnShutdown()
+	if err := whB.OnStartup(); err != nil {
+		t.Fatalf("expected the second receiver to share the listener, got %v", err)
+	}
+
+	url := "http://" + webhookListeners.listeners[cfg.WebhookAddr].ln.Addr().String() + webhookPath
+	post := func() {
+		req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(testEvent))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("post event: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("want status %d, got %d", http.StatusAccepted, resp.StatusCode)
+		}
+	}
+	post()
+	if a.Load() != 1 || b.Load() != 1 {
+		t.Fatalf("expected both receivers to get the event, got %d and %d", a.Load(), b.Load())
+	}
+
+	whB.OnShutdown()
+	post()
+	if a.Load() != 2 || b.Load() != 1 {
+		t.Fatalf("expected only the remaining receiver to get the event, got %d and %d", a.Load(), b.Load())
+	}
+}
@@ -0,0 +1,84 @@
+package ztnet
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/coredns/coredns/plugin/transfer"
+
+	"github.com/miekg/dns"
+)
+
+// Transfer implements the transfer.Transferer interface.
+func (z *ZTNet) Transfer(zone string, serial uint32) (<-chan []dns.RR, error) {
+	zone = dns.CanonicalName(zone)
+	if z.matchZone(zone) != zone {
+		return nil, transfer.ErrNotAuthoritative
+	}
+	if !z.Cache.Loaded(zone) {
+		return nil, fmt.Errorf("zone %s has no network data yet", zone)
+	}
+
+	soa := z.soa(zone)
+	entries := z.Cache.Entries(zone)
+	ttl := z.ttl(zone)
+	if z.Config.Wildcard {
+		// Secondaries serve the wildcard records like we do, names that exist are not covered.
+		for name, ips := range maps.Clone(entries.Hosts) {
+			if name != zone {
+				entries.Hosts["*."+name] = ips
+			}
+		}
+	}
+
+	names := slices.Collect(maps.Keys(entries.Hosts))
+	names = slices.AppendSeq(names, maps.Keys(entries.PTR))
+	names = slices.AppendSeq(names, maps.Keys(entries.CNAME))
+	names = slices.AppendSeq(names, maps.Keys(entries.TXT))
+	names = slices.AppendSeq(names, maps.Keys(entries.SRV))
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	ch := make(chan []dns.RR)
+	go func() {
+		if serial != 0 && soa.(*dns.SOA).Serial == serial { // ixfr fallback, only send SOA
+			ch <- []dns.RR{soa}
+			close(ch)
+			return
+		}
+
+		ch <- []dns.RR{soa, z.ns(zone)}
+		if rrs := z.nsAddrs(zone, 0); len(rrs) > 0 {
+			ch <- rrs
+		}
+		for _, name := range names {
+			// Names of a more specific zone are transferred with that zone.
+			if z.matchZone(name) != zone {
+				continue
+			}
+			rrs := addressRecords(name, 0, ttl, entries.Hosts[name])
+			rrs = append(rrs, ptrRecords(name, ttl, entries.PTR[name])...)
+			if target, ok := entries.CNAME[name]; ok {
+				rrs = append(rrs, cnameRecord(name, ttl, target))
+			}
+			rrs = append(rrs, txtRecords(name, ttl, entries.TXT[name])...)
+			rrs = append(rrs, srvRecords(name, ttl, entries.SRV[name])...)
+			if len(rrs) > 0 {
+				ch <- rrs
+			}
+		}
+		ch <- []dns.RR{soa}
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// notify sends notifies for zones to the secondaries configured in the transfer plugin.
+func (z *ZTNet) notify(zones []string) {
+	for _, zone := range zones {
+		if err := z.transfer.Notify(zone); err != nil {
+			log.Warningf("Failed sending notifies for %q: %s", zone, err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package ztnet
+
+import (
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/transfer"
+
+	"github.com/miekg/dns"
+)
+
+func TestTransfer(t *testing.T) {
+	z := newPlugin()
+	ch, err := z.Transfer("home.lan.", 0)
+	if err != nil {
+		t.Fatalf("Transfer error: %v", err)
+	}
+
+	var rrs []dns.RR
+	for x := range ch {
+		rrs = append(rrs, x...)
+	}
+	want := []uint16{dns.TypeSOA, dns.TypeNS, dns.TypeA, dns.TypeAAAA, dns.TypeSOA}
+	if len(rrs) != len(want) {
+		t.Fatalf("want %d records, got %d: %v", len(want), len(rrs), rrs)
+	}
+	for i, rr := range rrs {
+		if rr.Header().Rrtype != want[i] {
+			t.Fatalf("record %d: want type %s, got %s", i, dns.TypeToString[want[i]], rr)
+		}
+	}
+}
+
+func TestTransferNameServer(t *testing.T) {
+	z := newPlugin()
+	z.localIPs = []net.IP{net.ParseIP("10.0.0.53")}
+	ch, err := z.Transfer("home.lan.", 0)
+	if err != nil {
+		t.Fatalf("Transfer error: %v", err)
+	}
+
+	var rrs []dns.RR
+	for x := range ch {
+		rrs = append(rrs, x...)
+	}
+	if len(rrs) < 3 || rrs[2].Header().Name != rrs[1].(*dns.NS).Ns || rrs[2].Header().Rrtype != dns.TypeA {
+		t.Fatalf("expected glue after the NS record, got %v", rrs)
+	}
+}
+
+func TestTransferCustomRecords(t *testing.T) {
+	z := newPlugin()
+	z.Cache.Replace(&Records{
+		Hosts:  map[string][]net.IP{"node.home.lan.": {net.ParseIP("10.0.0.2")}},
+		CNAME:  map[string]string{"gitlab.home.lan.": "node.home.lan."},
+		TXT:    map[string][]string{"node.home.lan.": {"rack 4"}},
+		SRV:    map[string][]SRV{"_ssh._tcp.home.lan.": {{Port: 22, Target: "node.home.lan."}}},
+		Zones:  []string{"home.lan."},
+		Loaded: []string{"home.lan."},
+	})
+	ch, err := z.Transfer("home.lan.", 0)
+	if err != nil {
+		t.Fatalf("Transfer error: %v", err)
+	}
+
+	var rrs []dns.RR
+	for x := range ch {
+		rrs = append(rrs, x...)
+	}
+	want := []uint16{dns.TypeSOA, dns.TypeNS, dns.TypeSRV, dns.TypeCNAME, dns.TypeA, dns.TypeTXT, dns.TypeSOA}
+	if len(rrs) != len(want) {
+		t.Fatalf("want %d records, got %d: %v", len(want), len(rrs), rrs)
+	}
+	for i, rr := range rrs {
+		if rr.Header().Rrtype != want[i] {
+			t.Fatalf("record %d: want type %s, got %s", i, dns.TypeToString[want[i]], rr)
+		}
+	}
+}
+
+func TestTransferWildcard(t *testing.T) {
+	z := newPlugin()
+	z.Config.Wildcard = true
+	ch, err := z.Transfer("home.lan.", 0)
+	if err != nil {
+		t.Fatalf("Transfer error: %v", err)
+	}
+
+	var names []string
+	for x := range ch {
+		for _, rr := range x {
+			names = append(names, rr.Header().Name)
+		}
+	}
+	if !slices.Contains(names, "*.node.home.lan.") {
+		t.Fatalf("expected wildcard records, got %v", names)
+	}
+}
+
+func TestTransferReverse(t *testing.T) {
+	z := newPlugin()
+	ch, err := z.Transfer("0.0.10.in-addr.arpa.", 0)
+	if err != nil {
+		t.Fatalf("Transfer error: %v", err)
+	}
+
+	var rrs []dns.RR
+	for x := range ch {
+		rrs = append(rrs, x...)
+	}
+	if len(rrs) != 4 {
+		t.Fatalf("want 4 records, got %v", rrs)
+	}
+	if ptr, ok := rrs[2].(*dns.PTR); !ok || ptr.Ptr != "node.home.lan." {
+		t.Fatalf("want PTR record, got %s", rrs[2])
+	}
+}
+
+func TestTransferIXFRFallback(t *testing.T) {
+	z := newPlugin()
+	ch, err := z.Transfer("home.lan.", z.Cache.Serial("home.lan."))
+	if err != nil {
+		t.Fatalf("Transfer error: %v", err)
+	}
+
+	var rrs []dns.RR
+	for x := range ch {
+		rrs = append(rrs, x...)
+	}
+	if len(rrs) != 1 || rrs[0].Header().Rrtype != dns.TypeSOA {
+		t.Fatalf("want a single SOA record, got %v", rrs)
+	}
+}
+
+func TestTransferNotAuthoritative(t *testing.T) {
+	z := newPlugin()
+	for _, zone := range []string{"example.org.", "sub.home.lan.", "10.in-addr.arpa."} {
+		if _, err := z.Transfer(zone, 0); err != transfer.ErrNotAuthoritative {
+			t.Fatalf("%s: want ErrNotAuthoritative, got %v", zone, err)
+		}
+	}
+}
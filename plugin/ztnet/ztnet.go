@@ -2,10 +2,13 @@ package ztnet
 
 import (
 	"context"
-	"strings"
+	"net"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
 	"github.com/coredns/coredns/plugin/pkg/fall"
+	"github.com/coredns/coredns/plugin/transfer"
 	"github.com/coredns/coredns/request"
 
 	"github.com/miekg/dns"
@@ -13,11 +16,15 @@ import (
 
 // ZTNet is the CoreDNS ztnet plugin handler.
 type ZTNet struct {
-	Next   plugin.Handler
-	Config *Config
-	Cache  *RecordCache
-	Client *Client
-	Fall   fall.F
+	Next    plugin.Handler
+	Config  *Config
+	Cache   *RecordCache
+	Backend Backend
+	Fall    fall.F
+
+	transfer *transfer.Transfer
+	// localIPs are the addresses of the synthesized name server, see nsAddrs.
+	localIPs []net.IP
 }
 
 // Name implements plugin.Handler.
@@ -28,59 +35,229 @@ func (z *ZTNet) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 	state := request.Request{W: w, Req: r}
 	qname := state.QName()
 
-	if !z.matchesZone(qname) {
+	server := metrics.WithServer(ctx)
+
+	zone := z.matchZone(state.Name())
+	if zone == "" {
 		if z.Fall.Through(qname) {
+			queryCount.WithLabelValues(server, outcomeFallthrough).Inc()
 			return plugin.NextOrFailure(z.Name(), z.Next, ctx, w, r)
 		}
+		queryCount.WithLabelValues(server, outcomeRefused).Inc()
 		return dns.RcodeRefused, nil
 	}
 
-	if state.QType() != dns.TypeA && state.QType() != dns.TypeAAAA {
+	if !z.allowed(zone, net.ParseIP(state.IP())) {
 		if z.Fall.Through(qname) {
+			queryCount.WithLabelValues(server, outcomeFallthrough).Inc()
 			return plugin.NextOrFailure(z.Name(), z.Next, ctx, w, r)
 		}
-		m := new(dns.Msg)
-		m.SetReply(r)
-		m.Authoritative = true
-		if err := w.WriteMsg(m); err != nil {
-			return dns.RcodeServerFailure, err
+		queryCount.WithLabelValues(server, outcomeRefused).Inc()
+		return dns.RcodeRefused, nil
+	}
+
+	// Without network data every name would be NXDOMAIN, which resolvers cache.
+	if !z.Cache.Loaded(zone) {
+		if z.Fall.Through(qname) {
+			queryCount.WithLabelValues(server, outcomeFallthrough).Inc()
+			return plugin.NextOrFailure(z.Name(), z.Next, ctx, w, r)
 		}
-		return dns.RcodeSuccess, nil
+		queryCount.WithLabelValues(server, outcomeServfail).Inc()
+		return dns.RcodeServerFailure, nil
 	}
 
 	m := new(dns.Msg)
 	m.SetReply(r)
 	m.Authoritative = true
-	ttl := uint32(z.Config.DNSTTL.Seconds())
-
-	ips, ok := z.Cache.Lookup(qname)
-	if ok {
-		for _, ip := range ips {
-			switch state.QType() {
-			case dns.TypeA:
-				if ip4 := ip.To4(); ip4 != nil {
-					m.Answer = append(m.Answer, &dns.A{Hdr: dns.RR_Header{Name: qname, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, A: ip4})
-				}
-			case dns.TypeAAAA:
-				if ip.To4() == nil && ip.To16() != nil {
-					m.Answer = append(m.Answer, &dns.AAAA{Hdr: dns.RR_Header{Name: qname, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl}, AAAA: ip})
-				}
+	ttl := z.ttl(zone)
+	apex := dns.CanonicalName(qname) == zone
+	ns := dns.CanonicalName(qname) == nsName(zone)
+
+	qtype := state.QType()
+	switch {
+	case apex && qtype == dns.TypeSOA:
+		m.Answer = []dns.RR{z.soa(zone)}
+	case apex && qtype == dns.TypeNS:
+		m.Answer = []dns.RR{z.ns(zone)}
+		m.Extra = z.nsAddrs(zone, 0)
+	case ns:
+		if qtype == dns.TypeA || qtype == dns.TypeAAAA {
+			m.Answer = z.nsAddrs(zone, qtype)
+		}
+	default:
+		if target, ok := z.Cache.LookupCNAME(qname); ok {
+			m.Answer = []dns.RR{cnameRecord(qname, ttl, target)}
+			if qtype != dns.TypeCNAME {
+				m.Answer = append(m.Answer, z.records(target, qtype, ttl)...)
 			}
+			break
+		}
+		m.Answer = z.records(qname, qtype, ttl)
+		if qtype == dns.TypeSRV {
+			m.Extra = z.targets(m.Answer, ttl)
 		}
 	}
 
+	outcome := outcomeHit
+	if len(m.Answer) == 0 {
+		outcome = outcomeNoData
+		// Only on NXDOMAIN we will fallthrough.
+		if !apex && !ns && !z.Cache.Exists(qname) {
+			if z.Fall.Through(qname) {
+				queryCount.WithLabelValues(server, outcomeFallthrough).Inc()
+				return plugin.NextOrFailure(z.Name(), z.Next, ctx, w, r)
+			}
+			outcome = outcomeNXDomain
+			m.Rcode = dns.RcodeNameError
+		}
+		m.Ns = []dns.RR{z.soa(zone)}
+	}
+	queryCount.WithLabelValues(server, outcome).Inc()
+
 	if err := w.WriteMsg(m); err != nil {
 		return dns.RcodeServerFailure, err
 	}
 	return dns.RcodeSuccess, nil
 }
 
-func (z *ZTNet) matchesZone(qname string) bool {
-	longest := ""
+// records returns the records of type qtype owned by name.
+func (z *ZTNet) records(name string, qtype uint16, ttl uint32) []dns.RR {
+	switch qtype {
+	case dns.TypePTR:
+		names, _ := z.Cache.LookupPTR(name)
+		return ptrRecords(name, ttl, names)
+	case dns.TypeA, dns.TypeAAAA:
+		ips, _ := z.Cache.Lookup(name)
+		return addressRecords(name, qtype, ttl, ips)
+	case dns.TypeTXT:
+		txts, _ := z.Cache.LookupTXT(name)
+		return txtRecords(name, ttl, txts)
+	case dns.TypeSRV:
+		srvs, _ := z.Cache.LookupSRV(name)
+		return srvRecords(name, ttl, srvs)
+	}
+	return nil
+}
+
+// targets returns the address records of the targets of SRV records, for the additional section.
+func (z *ZTNet) targets(rrs []dns.RR, ttl uint32) []dns.RR {
+	var extra []dns.RR
+	seen := make(map[string]struct{})
+	for _, rr := range rrs {
+		srv, ok := rr.(*dns.SRV)
+		if !ok {
+			continue
+		}
+		if _, ok := seen[srv.Target]; ok {
+			continue
+		}
+		seen[srv.Target] = struct{}{}
+		ips, _ := z.Cache.Lookup(srv.Target)
+		extra = append(extra, addressRecords(srv.Target, 0, ttl, ips)...)
+	}
+	return extra
+}
+
+// matchZone returns the longest configured, discovered or derived zone qname belongs to, or the empty
+// string. Reverse zones are only considered when reverse lookups are enabled.
+func (z *ZTNet) matchZone(qname string) string {
+	zones := z.Cache.Zones()
 	for _, nz := range z.Config.Networks {
-		if strings.HasSuffix(qname, nz.Zone) && len(nz.Zone) > len(longest) {
-			longest = nz.Zone
+		zones = append(zones, nz.Zone)
+	}
+	if !z.Config.NoReverse && dnsutil.IsReverse(qname) > 0 {
+		zones = append(zones, z.Config.ReverseZones...)
+		zones = append(zones, z.Cache.ReverseZones()...)
+	}
+	return plugin.Zones(zones).Matches(qname)
+}
+
+func (z *ZTNet) ttl(zone string) uint32 { return uint32(z.Config.dnsTTL(zone).Seconds()) }
+
+// soa returns the synthesized SOA record for zone.
+func (z *ZTNet) soa(zone string) dns.RR {
+	ttl := z.ttl(zone)
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: ttl},
+		Ns:      nsName(zone),
+		Mbox:    dnsutil.Join("hostmaster", zone),
+		Serial:  z.Cache.Serial(zone),
+		Refresh: 7200,
+		Retry:   1800,
+		Expire:  86400,
+		Minttl:  ttl,
+	}
+}
+
+// ns returns the synthesized NS record for zone.
+func (z *ZTNet) ns(zone string) dns.RR {
+	return &dns.NS{
+		Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: z.ttl(zone)},
+		Ns:  nsName(zone),
+	}
+}
+
+// nsAddrs returns the A or AAAA records, depending on qtype, of the name server of zone. Like the
+// kubernetes plugin, these are the addresses CoreDNS is bound to.
+func (z *ZTNet) nsAddrs(zone string, qtype uint16) []dns.RR {
+	return addressRecords(nsName(zone), qtype, z.ttl(zone), z.localIPs)
+}
+
+// nsName returns the name of the synthesized name server of zone.
+func nsName(zone string) string { return dnsutil.Join("ns.dns", zone) }
+
+// addressRecords returns the A or AAAA records for ips, depending on qtype. When qtype is neither,
+// both are returned.
+func addressRecords(name string, qtype uint16, ttl uint32, ips []net.IP) []dns.RR {
+	var rrs []dns.RR
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			if qtype != dns.TypeAAAA {
+				rrs = append(rrs, &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, A: ip4})
+			}
+			continue
+		}
+		if ip.To16() != nil && qtype != dns.TypeA {
+			rrs = append(rrs, &dns.AAAA{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl}, AAAA: ip})
+		}
+	}
+	return rrs
+}
+
+// ptrRecords returns the PTR records pointing to names.
+func ptrRecords(name string, ttl uint32, names []string) []dns.RR {
+	rrs := make([]dns.RR, len(names))
+	for i, n := range names {
+		rrs[i] = &dns.PTR{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl}, Ptr: n}
+	}
+	return rrs
+}
+
+// cnameRecord returns the CNAME record pointing to target.
+func cnameRecord(name string, ttl uint32, target string) dns.RR {
+	return &dns.CNAME{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: ttl}, Target: target}
+}
+
+// txtRecords returns a TXT record for every string in txts.
+func txtRecords(name string, ttl uint32, txts []string) []dns.RR {
+	rrs := make([]dns.RR, len(txts))
+	for i, txt := range txts {
+		rrs[i] = &dns.TXT{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl}, Txt: []string{txt}}
+	}
+	return rrs
+}
+
+// srvRecords returns the SRV records for srvs.
+func srvRecords(name string, ttl uint32, srvs []SRV) []dns.RR {
+	rrs := make([]dns.RR, len(srvs))
+	for i, srv := range srvs {
+		rrs[i] = &dns.SRV{
+			Hdr:      dns.RR_Header{Name: name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: ttl},
+			Priority: srv.Priority,
+			Weight:   srv.Weight,
+			Port:     srv.Port,
+			Target:   srv.Target,
 		}
 	}
-	return longest != ""
+	return rrs
 }
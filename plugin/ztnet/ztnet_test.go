@@ -3,6 +3,7 @@ package ztnet
 import (
 	"context"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,8 +16,16 @@ import (
 
 func newPlugin() *ZTNet {
 	rc := &RecordCache{}
-	rc.Replace(map[string][]net.IP{
-		"node.home.lan.": {net.ParseIP("10.0.0.2"), net.ParseIP("fc00::1")},
+	rc.Replace(&Records{
+		Hosts: map[string][]net.IP{
+			"node.home.lan.": {net.ParseIP("10.0.0.2"), net.ParseIP("fc00::1")},
+		},
+		PTR: map[string][]string{
+			"2.0.0.10.in-addr.arpa.": {"node.home.lan."},
+		},
+		ReverseZones: []string{"0.0.10.in-addr.arpa."},
+		Zones:        []string{"home.lan."},
+		Loaded:       []string{"0.0.10.in-addr.arpa.", "home.lan."},
 	})
 	return &ZTNet{Config: &Config{Networks: []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}}, DNSTTL: 30 * time.Second}, Cache: rc}
 }
@@ -75,6 +84,126 @@ func TestServeDNSUnknownNameInZone(t *testing.T) {
 	if err != nil || rcode != dns.RcodeSuccess || len(rec.Msg.Answer) != 0 {
 		t.Fatalf("rcode=%d err=%v answer=%v", rcode, err, rec.Msg.Answer)
 	}
+	if rec.Msg.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN, got %s", dns.RcodeToString[rec.Msg.Rcode])
+	}
+	if len(rec.Msg.Ns) != 1 || rec.Msg.Ns[0].Header().Name != "home.lan." || rec.Msg.Ns[0].Header().Rrtype != dns.TypeSOA {
+		t.Fatalf("expected SOA in authority, got %v", rec.Msg.Ns)
+	}
+}
+
+func TestServeDNSNoData(t *testing.T) {
+	z := newPlugin()
+	z.Cache.Replace(&Records{
+		Hosts:  map[string][]net.IP{"v4only.sub.home.lan.": {net.ParseIP("10.0.0.3")}},
+		Zones:  []string{"home.lan."},
+		Loaded: []string{"home.lan."},
+	})
+	for _, q := range []struct {
+		name  string
+		qtype uint16
+	}{
+		{"v4only.sub.home.lan.", dns.TypeAAAA},
+		{"v4only.sub.home.lan.", dns.TypeTXT},
+		{"sub.home.lan.", dns.TypeA},
+		{"home.lan.", dns.TypeA},
+	} {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		m := new(dns.Msg)
+		m.SetQuestion(q.name, q.qtype)
+		rcode, err := z.ServeDNS(context.Background(), rec, m)
+		if err != nil || rcode != dns.RcodeSuccess {
+			t.Fatalf("%s: rcode=%d err=%v", q.name, rcode, err)
+		}
+		if rec.Msg.Rcode != dns.RcodeSuccess || len(rec.Msg.Answer) != 0 {
+			t.Fatalf("%s: expected NODATA, got rcode=%s answer=%v", q.name, dns.RcodeToString[rec.Msg.Rcode], rec.Msg.Answer)
+		}
+		if len(rec.Msg.Ns) != 1 || rec.Msg.Ns[0].Header().Rrtype != dns.TypeSOA {
+			t.Fatalf("%s: expected SOA in authority, got %v", q.name, rec.Msg.Ns)
+		}
+	}
+}
+
+func TestServeDNSApex(t *testing.T) {
+	z := newPlugin()
+	serial := z.Cache.Serial("home.lan.")
+	if serial == 0 {
+		t.Fatal("expected serial for home.lan.")
+	}
+	for _, qtype := range []uint16{dns.TypeSOA, dns.TypeNS} {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		m := new(dns.Msg)
+		m.SetQuestion("Home.Lan.", qtype)
+		rcode, err := z.ServeDNS(context.Background(), rec, m)
+		if err != nil || rcode != dns.RcodeSuccess {
+			t.Fatalf("rcode=%d err=%v", rcode, err)
+		}
+		if len(rec.Msg.Answer) != 1 || rec.Msg.Answer[0].Header().Rrtype != qtype {
+			t.Fatalf("unexpected answer %v", rec.Msg.Answer)
+		}
+		if soa, ok := rec.Msg.Answer[0].(*dns.SOA); ok && soa.Serial != serial {
+			t.Fatalf("want serial %d, got %d", serial, soa.Serial)
+		}
+	}
+}
+
+func TestServeDNSNXDomainFallthrough(t *testing.T) {
+	z := newPlugin()
+	z.Fall.SetZonesFromArgs(nil)
+	z.Next = test.NextHandler(dns.RcodeServerFailure, nil)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	m := new(dns.Msg)
+	m.SetQuestion("unknown.home.lan.", dns.TypeA)
+	if rcode, _ := z.ServeDNS(context.Background(), rec, m); rcode != dns.RcodeServerFailure {
+		t.Fatalf("expected next plugin to be called, got rcode=%d", rcode)
+	}
+
+	rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	m.SetQuestion("node.home.lan.", dns.TypeMX)
+	if rcode, _ := z.ServeDNS(context.Background(), rec, m); rcode != dns.RcodeSuccess || rec.Msg.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NODATA without fallthrough, got rcode=%d", rcode)
+	}
+}
+
+func TestServeDNSNotLoaded(t *testing.T) {
+	networks := []NetworkZone{{Zone: "home.lan.", NetworkID: "8056c2e21c000001"}}
+	cfg := &Config{Networks: networks, ReverseZones: []string{"10.in-addr.arpa."}, DNSTTL: 30 * time.Second}
+	rc := &RecordCache{}
+	rc.Replace(rc.build(cfg, networks, time.Now()))
+	z := &ZTNet{Config: cfg, Cache: rc}
+	for _, q := range []string{"home.lan.", "node.home.lan.", "2.0.0.10.in-addr.arpa."} {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		m := new(dns.Msg)
+		m.SetQuestion(q, dns.TypeSOA)
+		if rcode, err := z.ServeDNS(context.Background(), rec, m); err != nil || rcode != dns.RcodeServerFailure {
+			t.Fatalf("%s: expected SERVFAIL before the first refresh, got rcode=%d err=%v", q, rcode, err)
+		}
+	}
+	if _, err := z.Transfer("home.lan.", 0); err == nil {
+		t.Fatal("expected transfer to fail before the first refresh")
+	}
+
+	z.Fall.SetZonesFromArgs(nil)
+	z.Next = test.NextHandler(dns.RcodeNameError, nil)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	m := new(dns.Msg)
+	m.SetQuestion("node.home.lan.", dns.TypeA)
+	if rcode, _ := z.ServeDNS(context.Background(), rec, m); rcode != dns.RcodeNameError {
+		t.Fatalf("expected next plugin to be called, got rcode=%d", rcode)
+	}
+
+	rc.networks = map[string]*networkState{"8056c2e21c000001": {info: &NetworkInfo{}, members: []Member{
+		{Authorized: true, ID: "efcc1b0947", Name: "node", IPs: []net.IP{net.ParseIP("10.0.0.2")}},
+	}}}
+	rc.Replace(rc.build(cfg, networks, time.Now()))
+	for _, q := range []string{"node.home.lan.", "2.0.0.10.in-addr.arpa."} {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		m := new(dns.Msg)
+		m.SetQuestion(q, dns.TypeSOA)
+		if rcode, err := z.ServeDNS(context.Background(), rec, m); err != nil || rcode != dns.RcodeSuccess {
+			t.Fatalf("%s: expected an answer once loaded, got rcode=%d err=%v", q, rcode, err)
+		}
+	}
 }
 
 func TestServeDNSOutsideZoneRefused(t *testing.T) {
@@ -107,3 +236,172 @@ func TestServeDNSOutsideZoneFallthrough(t *testing.T) {
 		t.Fatalf("rcode=%d err=%v", rcode, err)
 	}
 }
+
+func TestServeDNSPTR(t *testing.T) {
+	z := newPlugin()
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	m := new(dns.Msg)
+	m.SetQuestion("2.0.0.10.in-addr.arpa.", dns.TypePTR)
+	rcode, err := z.ServeDNS(context.Background(), rec, m)
+	if err != nil || rcode != dns.RcodeSuccess {
+		t.Fatalf("rcode=%d err=%v", rcode, err)
+	}
+	if len(rec.Msg.Answer) != 1 || rec.Msg.Answer[0].(*dns.PTR).Ptr != "node.home.lan." {
+		t.Fatalf("unexpected answer %#v", rec.Msg.Answer)
+	}
+}
+
+func TestServeDNSPTRConfiguredZone(t *testing.T) {
+	z := newPlugin()
+	z.Config.ReverseZones = []string{"10.in-addr.arpa."}
+	z.Cache.Replace(&Records{Zones: []string{"10.in-addr.arpa.", "home.lan."}, Loaded: []string{"10.in-addr.arpa.", "home.lan."}})
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	m := new(dns.Msg)
+	m.SetQuestion("9.9.9.10.in-addr.arpa.", dns.TypePTR)
+	rcode, err := z.ServeDNS(context.Background(), rec, m)
+	if err != nil || rcode != dns.RcodeSuccess || len(rec.Msg.Answer) != 0 {
+		t.Fatalf("rcode=%d err=%v answer=%v", rcode, err, rec.Msg.Answer)
+	}
+	if rec.Msg.Rcode != dns.RcodeNameError || len(rec.Msg.Ns) != 1 || rec.Msg.Ns[0].Header().Name != "10.in-addr.arpa." {
+		t.Fatalf("expected NXDOMAIN with SOA for 10.in-addr.arpa., got rcode=%d ns=%v", rec.Msg.Rcode, rec.Msg.Ns)
+	}
+}
+
+func TestServeDNSPTRNoReverse(t *testing.T) {
+	z := newPlugin()
+	z.Config.NoReverse = true
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	m := new(dns.Msg)
+	m.SetQuestion("2.0.0.10.in-addr.arpa.", dns.TypePTR)
+	rcode, err := z.ServeDNS(context.Background(), rec, m)
+	if err != nil || rcode != dns.RcodeRefused {
+		t.Fatalf("rcode=%d err=%v", rcode, err)
+	}
+}
+
+func TestServeDNSCustomRecords(t *testing.T) {
+	z := newPlugin()
+	z.Cache.Replace(&Records{
+		Hosts:  map[string][]net.IP{"node.home.lan.": {net.ParseIP("10.0.0.2")}},
+		CNAME:  map[string]string{"gitlab.home.lan.": "node.home.lan."},
+		TXT:    map[string][]string{"node.home.lan.": {"rack 4"}},
+		SRV:    map[string][]SRV{"_ssh._tcp.home.lan.": {{Port: 22, Target: "node.home.lan."}}},
+		Zones:  []string{"home.lan."},
+		Loaded: []string{"home.lan."},
+	})
+	tests := []struct {
+		name  string
+		qtype uint16
+		want  []uint16
+		extra int
+	}{
+		{"GitLab.home.lan.", dns.TypeA, []uint16{dns.TypeCNAME, dns.TypeA}, 0},
+		{"gitlab.home.lan.", dns.TypeCNAME, []uint16{dns.TypeCNAME}, 0},
+		{"gitlab.home.lan.", dns.TypeTXT, []uint16{dns.TypeCNAME, dns.TypeTXT}, 0},
+		{"node.home.lan.", dns.TypeTXT, []uint16{dns.TypeTXT}, 0},
+		{"_ssh._tcp.home.lan.", dns.TypeSRV, []uint16{dns.TypeSRV}, 1},
+		{"node.home.lan.", dns.TypeCNAME, nil, 0},
+	}
+	for _, tc := range tests {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		m := new(dns.Msg)
+		m.SetQuestion(tc.name, tc.qtype)
+		rcode, err := z.ServeDNS(context.Background(), rec, m)
+		if err != nil || rcode != dns.RcodeSuccess || rec.Msg.Rcode != dns.RcodeSuccess {
+			t.Fatalf("%s %s: rcode=%d err=%v", tc.name, dns.TypeToString[tc.qtype], rcode, err)
+		}
+		if len(rec.Msg.Answer) != len(tc.want) || len(rec.Msg.Extra) != tc.extra {
+			t.Fatalf("%s %s: unexpected answer %v extra %v", tc.name, dns.TypeToString[tc.qtype], rec.Msg.Answer, rec.Msg.Extra)
+		}
+		for i, rr := range rec.Msg.Answer {
+			if rr.Header().Rrtype != tc.want[i] {
+				t.Fatalf("%s %s: unexpected answer %v", tc.name, dns.TypeToString[tc.qtype], rec.Msg.Answer)
+			}
+		}
+	}
+}
+
+func TestServeDNSWildcard(t *testing.T) {
+	z := newPlugin()
+	z.Config.Wildcard = true
+	z.Cache.wildcard = true
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	m := new(dns.Msg)
+	m.SetQuestion("API.node.home.lan.", dns.TypeA)
+	if _, err := z.ServeDNS(context.Background(), rec, m); err != nil {
+		t.Fatalf("ServeDNS error: %v", err)
+	}
+	if len(rec.Msg.Answer) != 1 || rec.Msg.Answer[0].Header().Name != "API.node.home.lan." {
+		t.Fatalf("unexpected answer %v", rec.Msg.Answer)
+	}
+
+	rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	m.SetQuestion("api.node.home.lan.", dns.TypeTXT)
+	if _, err := z.ServeDNS(context.Background(), rec, m); err != nil {
+		t.Fatalf("ServeDNS error: %v", err)
+	}
+	if rec.Msg.Rcode != dns.RcodeSuccess || len(rec.Msg.Answer) != 0 {
+		t.Fatalf("expected NODATA, got rcode=%d answer=%v", rec.Msg.Rcode, rec.Msg.Answer)
+	}
+}
+
+func TestServeDNSNetworkTTL(t *testing.T) {
+	z := newPlugin()
+	z.Config.Networks[0].DNSTTL = 10 * time.Second
+
+	for _, q := range []struct {
+		name  string
+		qtype uint16
+		ttl   uint32
+	}{
+		{"node.home.lan.", dns.TypeA, 10},
+		{"home.lan.", dns.TypeSOA, 10},
+		{"2.0.0.10.in-addr.arpa.", dns.TypePTR, 30},
+	} {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		m := new(dns.Msg)
+		m.SetQuestion(q.name, q.qtype)
+		if _, err := z.ServeDNS(context.Background(), rec, m); err != nil {
+			t.Fatalf("ServeDNS error: %v", err)
+		}
+		if len(rec.Msg.Answer) != 1 || rec.Msg.Answer[0].Header().Ttl != q.ttl {
+			t.Fatalf("%s: expected TTL %d, got %v", q.name, q.ttl, rec.Msg.Answer)
+		}
+	}
+}
+
+func TestServeDNSNameServer(t *testing.T) {
+	z := newPlugin()
+	z.localIPs = []net.IP{net.ParseIP("10.0.0.53"), net.ParseIP("fc00::53")}
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	m := new(dns.Msg)
+	m.SetQuestion("home.lan.", dns.TypeNS)
+	if _, err := z.ServeDNS(context.Background(), rec, m); err != nil {
+		t.Fatalf("ServeDNS error: %v", err)
+	}
+	ns := rec.Msg.Answer[0].(*dns.NS).Ns
+	if len(rec.Msg.Extra) != 2 || rec.Msg.Extra[0].Header().Name != ns {
+		t.Fatalf("expected glue for %s, got %v", ns, rec.Msg.Extra)
+	}
+
+	for qtype, want := range map[uint16]string{dns.TypeA: "10.0.0.53", dns.TypeAAAA: "fc00::53", dns.TypeTXT: ""} {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		m := new(dns.Msg)
+		m.SetQuestion(ns, qtype)
+		rcode, err := z.ServeDNS(context.Background(), rec, m)
+		if err != nil || rcode != dns.RcodeSuccess || rec.Msg.Rcode != dns.RcodeSuccess {
+			t.Fatalf("%s: rcode=%d msg rcode=%d err=%v", dns.TypeToString[qtype], rcode, rec.Msg.Rcode, err)
+		}
+		if want == "" {
+			if len(rec.Msg.Answer) != 0 {
+				t.Fatalf("expected NODATA for TXT, got %v", rec.Msg.Answer)
+			}
+			continue
+		}
+		if len(rec.Msg.Answer) != 1 || !strings.HasSuffix(rec.Msg.Answer[0].String(), "\t"+want) {
+			t.Fatalf("%s: want %s, got %v", dns.TypeToString[qtype], want, rec.Msg.Answer)
+		}
+	}
+}